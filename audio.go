@@ -0,0 +1,85 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 常见音频扩展名对应的MIME类型 部分系统的mime表缺失这些类型
+var audioMimeTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".flac": "audio/flac",
+	".ogg":  "audio/ogg",
+	".wav":  "audio/wav",
+}
+
+// audioMimeType 根据扩展名推断音频的MIME类型
+func audioMimeType(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	if mimeType, ok := audioMimeTypes[ext]; ok {
+		return mimeType
+	}
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// isManagedPath 判断路径(解析软链接之后)是否位于受管理的文件夹中
+func isManagedPath(path string) bool {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	for _, base := range []string{spotifyLocalPath, spotifyLocalTempPath} {
+		realBase, err := filepath.EvalSymlinks(base)
+		if err != nil {
+			continue
+		}
+		if util.IsSubPath(realBase, realPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// stagedFilePath 根据路由中的*filepath参数(歌单名/文件名)得到暂存区中的文件路径
+// 只允许访问受管理文件夹里的文件 防止../穿越
+func stagedFilePath(c *gin.Context) (string, bool) {
+	relPath := filepath.FromSlash(strings.TrimPrefix(c.Param("filepath"), "/"))
+	stagedPath := filepath.Join(spotifyLocalTempPath, relPath)
+	if !util.IsSubPath(spotifyLocalTempPath, stagedPath) || !isManagedPath(stagedPath) {
+		c.String(http.StatusForbidden, "Forbidden")
+		return "", false
+	}
+	return stagedPath, true
+}
+
+// serveStagedAudio 试听spotify_local_temp中的曲目 支持Range请求
+func serveStagedAudio(c *gin.Context) {
+	audioPath, ok := stagedFilePath(c)
+	if !ok {
+		return
+	}
+	audioFile, err := os.Open(audioPath)
+	if err != nil {
+		c.String(http.StatusNotFound, "Not Found")
+		return
+	}
+	defer audioFile.Close()
+	stat, err := audioFile.Stat()
+	if err != nil || stat.IsDir() {
+		c.String(http.StatusNotFound, "Not Found")
+		return
+	}
+	c.Header("Content-Type", audioMimeType(audioPath))
+	//ServeContent会处理Range/If-Modified-Since等请求头
+	http.ServeContent(c.Writer, c.Request, stat.Name(), stat.ModTime(), audioFile)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// 子命令 本地曲库的维护操作 不需要启动授权流程
+// 用法: spotify-local-manager.exe <子命令> [参数]
+var commands = map[string]func(args []string){
+	"dedupe":  runDedupe,
+	"export":  runExport,
+	"import":  runImport,
+	"restore": runRestore,
+	"tags":    runTags,
+}
+
+// runCommand 执行子命令
+func runCommand(name string, args []string) {
+	command, ok := commands[name]
+	if !ok {
+		names := make([]string, 0, len(commands))
+		for commandName := range commands {
+			names = append(names, commandName)
+		}
+		sort.Strings(names)
+		fmt.Printf("未知的子命令: %v, 可用的子命令: %v\n", name, names)
+		os.Exit(1)
+	}
+	command(args)
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"os"
 	"sort"
+	"strings"
 )
 
+// 共享的标准输入读取器 多个bufio.Reader同时读取标准输入会丢失缓冲的数据
+var stdinReader = bufio.NewReader(os.Stdin)
+
 // pass	此为仿照python的pass方便调试 写的占位符函数 本身没有任何功能 可能的结果
 func pass() {
 }
@@ -34,3 +40,11 @@ func specialHandleError(err error, logicFunc func(v ...interface{}) []interface{
 		logicFunc()
 	}
 }
+
+// confirm 在控制台询问用户 输入y确认
+func confirm(prompt string) bool {
+	fmt.Print(prompt + " (y/N): ")
+	answer, _ := stdinReader.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// appConfig 用户配置 存放于 ~/.spotifyLocalManager/config.json
+type appConfig struct {
+	//web服务监听的地址 默认只监听本机回环地址 需要其他设备访问时显式改为0.0.0.0或局域网IP
+	ListenHost string
+	//收件箱文件夹 为空时使用spotify_local同级的spotify_inbox
+	InboxPath string
+	//收件箱路由规则 按顺序匹配 第一条命中的规则生效
+	Rules []routeRule
+	//标签声明为ISO-8859-1但实际是中文旧编码时 无法区分GBK和Big5的情况下优先使用的编码 gbk/big5 填none关闭转码
+	LegacyEncoding string
+	//标签缺失时从文件名推断标签的模板 按顺序匹配 占位符: {artist} {title} {album} {track} {year} {_}
+	FileNamePatterns []string
+	//是否把从文件名推断出的字段写入标签
+	WriteInferredTags bool
+	//匹配曲目时允许的时长误差(秒) 用于区分同一首歌的不同版本 0表示不比较时长
+	DurationTolerance int
+	//移动文件时目标文件已存在的处理策略 skip: 跳过 suffix: 两个都保留(加后缀) replace-identical: 内容相同时替换否则跳过 ask: 在网页上决定
+	ConflictPolicy string
+	//暂存策略 move: 把待分类的文件移动到spotify_local_temp link: 在spotify_local_temp中创建链接 原文件留在原处
+	StagingStrategy string
+	//文件一直被占用时是否关闭spotify never: 从不关闭 ask: 在控制台询问 ask-ui: 在分类预览页面中询问 kill: 重试SpotifyCloseRetries次后关闭
+	//关闭时先正常关闭 超时后强制结束 只有本工具关闭的spotify才会在处理完成后重新打开
+	SpotifyClosePolicy string
+	//策略为kill时 文件被占用重试多少次后关闭spotify
+	SpotifyCloseRetries int
+	//是否在分类开始时自动把spotify的本地文件来源切换为spotify_local_temp 分类完成后还原
+	SwitchLocalSources bool
+	//spotify的prefs文件路径 为空时使用%APPDATA%\Spotify\prefs
+	SpotifyPrefsPath string
+	//prefs文件中本地文件来源的键 值为文件夹路径的JSON数组
+	SpotifyPrefsSourcesKey string
+}
+
+// routeRule 收件箱路由规则 所有非空条件都满足时命中 条件支持*和?通配符 不区分大小写
+type routeRule struct {
+	//规则名称 记录在路由日志中
+	Name string
+	//命中后移动到的歌单文件夹
+	PlayList string
+	//艺术家
+	Artist string
+	//专辑
+	Album string
+	//流派(TCON)
+	Genre string
+	//年份(TYER/TDRC)
+	Year string
+	//文件名
+	FileName string
+	//自定义ID3帧 如TCOM 自定义文本帧写作TXXX:描述
+	Frame string
+	//自定义ID3帧的值
+	FrameValue string
+}
+
+// 默认配置
+func defaultAppConfig() *appConfig {
+	return &appConfig{
+		ListenHost:             "127.0.0.1",
+		Rules:                  make([]routeRule, 0),
+		LegacyEncoding:         util.EncodingGBK,
+		DurationTolerance:      3,
+		ConflictPolicy:         conflictSuffix,
+		StagingStrategy:        stagingStrategyMove,
+		SpotifyClosePolicy:     spotifyCloseNever,
+		SpotifyCloseRetries:    3,
+		SpotifyPrefsSourcesKey: defaultPrefsSourcesKey,
+		FileNamePatterns: []string{
+			"{artist} - {title}",
+			"{track}. {title}",
+		},
+	}
+}
+
+// loadAppConfig 读取配置文件 不存在时写入一份默认配置
+func loadAppConfig(configPath string) *appConfig {
+	conf := defaultAppConfig()
+	configFile, err := os.Open(configPath)
+	if os.IsNotExist(err) {
+		saveAppConfig(configPath, conf)
+		return conf
+	} else if err != nil {
+		fmt.Println("无法读取配置文件, 使用默认配置: ", err)
+		return conf
+	}
+	defer configFile.Close()
+	//未出现在配置文件里的字段保留默认值
+	err = json.NewDecoder(configFile).Decode(conf)
+	if err != nil {
+		fmt.Println("配置文件解析失败, 使用默认配置: ", err)
+		return defaultAppConfig()
+	}
+	return conf
+}
+
+// saveAppConfig 保存配置文件
+func saveAppConfig(configPath string, conf *appConfig) {
+	configFile, err := os.Create(configPath)
+	if err != nil {
+		fmt.Println("无法创建配置文件: ", err)
+		return
+	}
+	defer configFile.Close()
+	encoder := json.NewEncoder(configFile)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(conf)
+}
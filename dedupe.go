@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// duplicateFile 重复组中的一个文件
+type duplicateFile struct {
+	//文件路径
+	Path string
+	//比特率 kbps
+	Bitrate int
+	//非空的文本帧数量 越多说明标签越完整
+	TagScore int
+	//元信息
+	Meta util.MP3MetaInfo
+}
+
+// duplicateGroup 一组重复的文件
+type duplicateGroup struct {
+	//判定依据 content:文件完全相同 audio:音频数据相同(仅标签不同) metadata:元信息相似
+	Reason string
+	//建议保留的文件
+	Keeper string
+	//组内的文件
+	Files []duplicateFile
+}
+
+// runDedupe 查找曲库中的重复文件
+// -quarantine: 将每组中除建议保留之外的文件移动到隔离文件夹
+func runDedupe(args []string) {
+	flags := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	quarantine := flags.Bool("quarantine", false, "将重复的文件(保留每组中建议保留的文件)移动到隔离文件夹")
+	_ = flags.Parse(args)
+
+	groups := findDuplicates([]string{spotifyLocalPath, spotifyLocalTempPath})
+	reportDuplicates(groups)
+	if *quarantine {
+		quarantineDuplicates(groups)
+	}
+}
+
+// findDuplicates 按文件内容 音频数据和元信息三种方式分组查找重复的文件
+func findDuplicates(roots []string) []duplicateGroup {
+	files := make([]duplicateFile, 0)
+	//按大小分组的已收集文件 用于排除指向同一文件的硬链接
+	seen := make(map[int64][]fs.FileInfo)
+	for _, root := range roots {
+		_ = filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+			if err != nil || info.IsDir() || !strings.HasSuffix(strings.ToLower(info.Name()), ".mp3") || info.Size() == 0 {
+				return nil
+			}
+			//暂存区中的软链接和硬链接指向的是同一个文件 不算重复
+			if info.Mode()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			for _, other := range seen[info.Size()] {
+				if os.SameFile(info, other) {
+					return nil
+				}
+			}
+			seen[info.Size()] = append(seen[info.Size()], info)
+			meta, err := util.ExtractMp3FromPath(path)
+			if err != nil {
+				return nil
+			}
+			bitrate, _ := util.ReadBitrate(path)
+			frames, _ := util.ReadTextFrames(path)
+			tagScore := 0
+			for _, value := range frames {
+				if strings.TrimSpace(value) != "" {
+					tagScore++
+				}
+			}
+			files = append(files, duplicateFile{Path: path, Bitrate: bitrate, TagScore: tagScore, Meta: meta})
+			return nil
+		})
+	}
+
+	groups := make([]duplicateGroup, 0)
+	//已经被更严格的方式分到同一组的文件集合 避免重复上报
+	reported := make(map[string]bool)
+	for _, reason := range []string{"content", "audio"} {
+		buckets := make(map[string][]duplicateFile)
+		for _, file := range files {
+			var hash string
+			var err error
+			if reason == "content" {
+				hash, err = util.HashFile(file.Path)
+			} else {
+				hash, err = util.HashAudioPayload(file.Path)
+			}
+			if err != nil {
+				continue
+			}
+			buckets[hash] = append(buckets[hash], file)
+		}
+		for _, bucket := range buckets {
+			if len(bucket) > 1 && !reported[groupKey(bucket)] {
+				reported[groupKey(bucket)] = true
+				groups = append(groups, newDuplicateGroup(reason, bucket))
+			}
+		}
+	}
+
+	//元信息相似: 先按艺术家和标题的首字分桶 再在桶内两两比较
+	buckets := make(map[string][]duplicateFile)
+	for _, file := range files {
+		artist := strings.ToLower(strings.TrimSpace(file.Meta.Artist))
+		title := strings.ToLower(strings.TrimSpace(file.Meta.Title))
+		if artist == "" || title == "" {
+			continue
+		}
+		artistRune, _ := utf8.DecodeRuneInString(artist)
+		titleRune, _ := utf8.DecodeRuneInString(title)
+		key := string(artistRune) + string(titleRune)
+		buckets[key] = append(buckets[key], file)
+	}
+	for _, bucket := range buckets {
+		grouped := make([]bool, len(bucket))
+		for i := range bucket {
+			if grouped[i] {
+				continue
+			}
+			similar := []duplicateFile{bucket[i]}
+			for j := i + 1; j < len(bucket); j++ {
+				if !grouped[j] &&
+					util.EvaluateSimilar(bucket[i].Meta.Artist, bucket[j].Meta.Artist) &&
+					util.EvaluateSimilar(bucket[i].Meta.Title, bucket[j].Meta.Title) &&
+					isSimilarDuration(bucket[i].Meta.Duration, bucket[j].Meta.Duration) {
+					grouped[j] = true
+					similar = append(similar, bucket[j])
+				}
+			}
+			if len(similar) > 1 && !reported[groupKey(similar)] {
+				reported[groupKey(similar)] = true
+				groups = append(groups, newDuplicateGroup("metadata", similar))
+			}
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Keeper < groups[j].Keeper
+	})
+	return groups
+}
+
+// groupKey 组内所有文件路径拼成的键
+func groupKey(files []duplicateFile) string {
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, "\n")
+}
+
+// newDuplicateGroup 创建重复组并选出建议保留的文件: 比特率更高 > 标签更完整 > 路径更短
+func newDuplicateGroup(reason string, files []duplicateFile) duplicateGroup {
+	sorted := make([]duplicateFile, len(files))
+	copy(sorted, files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Bitrate != sorted[j].Bitrate {
+			return sorted[i].Bitrate > sorted[j].Bitrate
+		}
+		if sorted[i].TagScore != sorted[j].TagScore {
+			return sorted[i].TagScore > sorted[j].TagScore
+		}
+		if len(sorted[i].Path) != len(sorted[j].Path) {
+			return len(sorted[i].Path) < len(sorted[j].Path)
+		}
+		return sorted[i].Path < sorted[j].Path
+	})
+	return duplicateGroup{Reason: reason, Keeper: sorted[0].Path, Files: sorted}
+}
+
+// reportDuplicates 打印重复文件报告 并写入duplicates.json
+func reportDuplicates(groups []duplicateGroup) {
+	if len(groups) == 0 {
+		fmt.Println("没有发现重复的文件!")
+	}
+	for _, group := range groups {
+		fmt.Printf("[%v] 建议保留: %v\n", group.Reason, group.Keeper)
+		for _, file := range group.Files[1:] {
+			fmt.Printf("    重复: %v (%dkbps)\n", file.Path, file.Bitrate)
+		}
+	}
+	duplicatesFile, err := os.Create(filepath.Join(spotifyConfigBasePath, "duplicates.json"))
+	if err != nil {
+		fmt.Println("无法创建duplicates.json: ", err)
+		return
+	}
+	defer duplicatesFile.Close()
+	encoder := json.NewEncoder(duplicatesFile)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(groups)
+}
+
+// quarantineDuplicates 将每组中除建议保留之外的文件移动到隔离文件夹
+// 只有内容或音频数据相同的组会自动隔离 元信息相似的组只是推测(可能是现场版或重制版) 逐组确认后才隔离
+func quarantineDuplicates(groups []duplicateGroup) {
+	moved := make(map[string]bool)
+	for _, group := range groups {
+		if moved[group.Keeper] {
+			//建议保留的文件已经在其他组中被隔离了 这一组保持不动
+			continue
+		}
+		if group.Reason == "metadata" && !confirm(fmt.Sprintf("[metadata] %v 与其他%d个文件的元信息相似, 是否隔离这些文件?", group.Keeper, len(group.Files)-1)) {
+			continue
+		}
+		for _, file := range group.Files[1:] {
+			if moved[file.Path] {
+				continue
+			}
+			dest, err := quarantineFile(file.Path, "duplicates")
+			if err != nil {
+				fmt.Println("隔离失败: ", err)
+				continue
+			}
+			moved[file.Path] = true
+			fmt.Printf("已隔离: %v => %v\n", file.Path, dest)
+		}
+	}
+}
+
+// quarantineFile 将文件移动到隔离文件夹spotify_quarantine/<类别>/ 保留其相对于曲库根目录的路径
+func quarantineFile(path string, category string) (string, error) {
+	rel, err := filepath.Rel(filepath.Dir(spotifyLocalPath), path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+	dest := filepath.Join(spotifyQuarantinePath, category, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	//隔离文件夹中已有同名文件时加上后缀 不覆盖
+	if _, err := os.Lstat(dest); err == nil {
+		dest = uniquePath(dest)
+	}
+	return dest, renameFile(path, dest)
+}
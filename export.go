@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nichuanfang/spotify-local-manager/util"
+	"github.com/zmb3/spotify/v2"
+)
+
+// 导出格式
+const (
+	exportM3U8 = "m3u8"
+	exportXSPF = "xspf"
+	exportCSV  = "csv"
+)
+
+// 待分类曲目导出的文件名
+const uncategorizedExportName = "uncategorized"
+
+// exportTrack 导出的一首曲目
+type exportTrack struct {
+	//文件的绝对路径
+	Path string
+	//元信息
+	Meta util.MP3MetaInfo
+}
+
+// exportList 导出的一个列表
+type exportList struct {
+	//列表名称 歌单名或uncategorized
+	Name string
+	//曲目 按导出的顺序排列
+	Tracks []exportTrack
+}
+
+// exportOptions 导出选项
+type exportOptions struct {
+	//导出格式
+	format string
+	//为true时写入绝对路径 否则写入相对于baseDir的路径
+	absolute bool
+	//相对路径的基准文件夹 即导出文件所在的文件夹
+	baseDir string
+}
+
+// runExport 把歌单文件夹和待分类曲目导出为播放列表或表格
+// -format: m3u8/xspf/csv/all -out: 导出文件夹 -absolute: 使用绝对路径 -offline: 不按spotify歌单排序
+// 参数后面可以指定只导出哪些歌单
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "all", "导出格式 m3u8/xspf/csv/all")
+	outDir := flags.String("out", defaultExportPath(), "导出文件夹")
+	absolute := flags.Bool("absolute", false, "写入绝对路径 默认写入相对于导出文件夹的路径")
+	offline := flags.Bool("offline", false, "不连接spotify 歌单中的曲目按文件名排序")
+	_ = flags.Parse(args)
+
+	formats := []string{exportM3U8, exportXSPF, exportCSV}
+	if *format != "all" {
+		if !isExportFormat(*format) {
+			fmt.Println("未知的导出格式: ", *format)
+			os.Exit(1)
+		}
+		formats = []string{*format}
+	}
+	if err := os.MkdirAll(*outDir, os.ModeDir); err != nil {
+		fmt.Println("无法创建导出文件夹: ", err)
+		os.Exit(1)
+	}
+
+	var sp *spotify.Client
+	if !*offline {
+		sp = newTokenClient()
+	}
+	ctx := context.Background()
+	lists := collectPlayListExports(ctx, sp, fetchExportPlayLists(ctx, sp), flags.Args())
+	if uncategorizedList, ok := collectUncategorizedExport(); ok && flags.NArg() == 0 {
+		lists = append(lists, uncategorizedList)
+	}
+	for _, list := range lists {
+		for _, listFormat := range formats {
+			options := exportOptions{format: listFormat, absolute: *absolute, baseDir: *outDir}
+			exportPath := filepath.Join(*outDir, util.SanitizeFileName(list.Name)+"."+listFormat)
+			if err := writeExportFile(exportPath, list, options); err != nil {
+				fmt.Printf("导出失败: %v: %v\n", exportPath, err)
+				continue
+			}
+			fmt.Printf("已导出%d首曲目: %v\n", len(list.Tracks), exportPath)
+		}
+	}
+}
+
+// defaultExportPath 默认的导出文件夹 与spotify_local同级的spotify_export
+func defaultExportPath() string {
+	return filepath.Join(filepath.Dir(spotifyLocalPath), "spotify_export")
+}
+
+// isExportFormat 是否为支持的导出格式
+func isExportFormat(format string) bool {
+	return format == exportM3U8 || format == exportXSPF || format == exportCSV
+}
+
+// newTokenClient 子命令使用token.json中的token创建spotify客户端 没有授权过时返回nil
+func newTokenClient() *spotify.Client {
+	principal, err := readPrincipal()
+	if err != nil {
+		fmt.Println("尚未授权, 歌单中的曲目按文件名排序")
+		return nil
+	}
+	loadOauthConfig()
+	setSessionToken(principal.Token)
+	return newSessionClient(context.Background())
+}
+
+// fetchExportPlayLists 查询用户的所有歌单 用于排序 sp为nil时返回空
+func fetchExportPlayLists(ctx context.Context, sp *spotify.Client) map[string]spotify.SimplePlaylist {
+	playLists := make(map[string]spotify.SimplePlaylist)
+	if sp == nil {
+		return playLists
+	}
+	user, err := sp.CurrentUser(ctx)
+	if err != nil {
+		fmt.Println("无法连接spotify, 歌单中的曲目按文件名排序: ", err)
+		return playLists
+	}
+	for _, playList := range getAllPlayLists(sp, ctx, user.ID) {
+		playLists[playList.Name] = playList
+	}
+	return playLists
+}
+
+// collectPlayListExports 收集歌单文件夹中的曲目 names为空时导出所有歌单
+// 在playLists中的歌单按spotify歌单中的顺序排列 歌单中没有的曲目按文件名排在后面
+func collectPlayListExports(ctx context.Context, sp *spotify.Client, playLists map[string]spotify.SimplePlaylist, names []string) []exportList {
+	var localMusicMetaData map[string][]util.MP3MetaInfo
+	if len(names) == 0 {
+		localMusicMetaData, _ = getLocalMusicMetaData()
+	} else {
+		localMusicMetaData = scanPlayListFolders(names)
+	}
+	lists := make([]exportList, 0)
+	for _, playListName := range sortedKeys(localMusicMetaData) {
+		if len(names) != 0 && !containsString(names, playListName) {
+			continue
+		}
+		tracks := localMusicMetaData[playListName]
+		if playList, ok := playLists[playListName]; ok && sp != nil {
+			tracks = orderByPlayList(sp, ctx, playList, tracks)
+		} else {
+			sortTracksByFileName(tracks)
+		}
+		list := exportList{Name: playListName, Tracks: make([]exportTrack, 0, len(tracks))}
+		for _, track := range tracks {
+			//多歌单归属的曲目 文件在LinkedFrom文件夹中
+			folder := track.PlayListName
+			if track.LinkedFrom != "" {
+				folder = track.LinkedFrom
+			}
+			list.Tracks = append(list.Tracks, exportTrack{Path: filepath.Join(spotifyLocalPath, folder, track.FileName), Meta: track})
+		}
+		lists = append(lists, list)
+	}
+	return lists
+}
+
+// scanPlayListFolders 只扫描指定的歌单文件夹 以及按membership.json有曲目归属于这些歌单的文件夹
+func scanPlayListFolders(names []string) map[string][]util.MP3MetaInfo {
+	targets := make([]string, 0, len(names))
+	for _, name := range names {
+		//歌单名来自网页请求 不能指向spotify_local以外的文件夹
+		if name != filepath.Base(name) || name == "." || name == ".." {
+			continue
+		}
+		targets = append(targets, name)
+	}
+	folders := append([]string(nil), targets...)
+	for key, playLists := range loadMembership() {
+		folder := path.Dir(key)
+		if containsString(folders, folder) {
+			continue
+		}
+		for _, name := range targets {
+			if containsString(playLists, name) {
+				folders = append(folders, folder)
+				break
+			}
+		}
+	}
+	res := make(map[string][]util.MP3MetaInfo)
+	for _, folder := range folders {
+		folderPath := filepath.Join(spotifyLocalPath, folder)
+		if info, err := os.Stat(folderPath); err != nil || !info.IsDir() {
+			continue
+		}
+		res[folder] = scanLibrary(folderPath).Tracks
+	}
+	getLibraryIndex().save()
+	applyMembership(res)
+	return res
+}
+
+// orderByPlayList 按spotify歌单中本地曲目的顺序排列 歌单中没有的曲目按文件名排在后面
+func orderByPlayList(sp *spotify.Client, ctx context.Context, playList spotify.SimplePlaylist, tracks []util.MP3MetaInfo) []util.MP3MetaInfo {
+	localItems, err := getLocalItemsByPlayList(sp, ctx, playList)
+	if err != nil {
+		sortTracksByFileName(tracks)
+		return tracks
+	}
+	remaining := append([]util.MP3MetaInfo(nil), tracks...)
+	res := make([]util.MP3MetaInfo, 0, len(tracks))
+	for _, item := range localItems {
+		found, fileName := isTrackInLocalTracks(item.Track, remaining)
+		if !found {
+			continue
+		}
+		for i, track := range remaining {
+			if track.FileName == fileName {
+				res = append(res, track)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+	sortTracksByFileName(remaining)
+	return append(res, remaining...)
+}
+
+// sortTracksByFileName 按文件名排序
+func sortTracksByFileName(tracks []util.MP3MetaInfo) {
+	sort.SliceStable(tracks, func(i, j int) bool {
+		return tracks[i].FileName < tracks[j].FileName
+	})
+}
+
+// collectUncategorizedExport 收集uncategorized.json中的待分类曲目 按歌单和文件名排序
+// 分类阶段进行中时使用内存中的最新数据
+func collectUncategorizedExport() (exportList, bool) {
+	data, ok := getUncategorized()
+	if !ok {
+		uncategorizedFile, err := os.Open(filepath.Join(spotifyConfigBasePath, "uncategorized.json"))
+		if err != nil {
+			return exportList{}, false
+		}
+		defer uncategorizedFile.Close()
+		data = make(map[string][]util.MP3MetaInfo)
+		if err := json.NewDecoder(uncategorizedFile).Decode(&data); err != nil {
+			fmt.Println("uncategorized.json解析失败: ", err)
+			return exportList{}, false
+		}
+	}
+	list := exportList{Name: uncategorizedExportName, Tracks: make([]exportTrack, 0)}
+	for _, playListName := range sortedKeys(data) {
+		tracks := append([]util.MP3MetaInfo(nil), data[playListName]...)
+		sortTracksByFileName(tracks)
+		for _, track := range tracks {
+			if track.LinkedFrom != "" {
+				continue
+			}
+			list.Tracks = append(list.Tracks, exportTrack{Path: filepath.Join(spotifyLocalTempPath, playListName, track.FileName), Meta: track})
+		}
+	}
+	return list, true
+}
+
+// writeExportFile 写入导出文件
+func writeExportFile(exportPath string, list exportList, options exportOptions) error {
+	exportFile, err := os.Create(exportPath)
+	if err != nil {
+		return err
+	}
+	defer exportFile.Close()
+	return writeExport(exportFile, list, options)
+}
+
+// writeExport 按格式写入导出内容
+func writeExport(w io.Writer, list exportList, options exportOptions) error {
+	switch options.format {
+	case exportM3U8:
+		return writeM3U8(w, list, options)
+	case exportXSPF:
+		return writeXSPF(w, list, options)
+	case exportCSV:
+		return writeCSV(w, list, options)
+	default:
+		return fmt.Errorf("未知的导出格式: %v", options.format)
+	}
+}
+
+// exportPath 曲目写入导出文件的路径 相对路径无法计算(如不在同一磁盘)时使用绝对路径
+func (options exportOptions) exportPath(path string) string {
+	if !options.absolute {
+		if rel, err := filepath.Rel(options.baseDir, path); err == nil {
+			return rel
+		}
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return absPath
+}
+
+// exportLocation XSPF中曲目的location 绝对路径为file:// URI 相对路径为转义后的相对URI
+func (options exportOptions) exportLocation(path string) string {
+	exportPath := options.exportPath(path)
+	segments := strings.Split(filepath.ToSlash(exportPath), "/")
+	for i, segment := range segments {
+		//windows盘符中的冒号保留
+		if i == 0 && strings.HasSuffix(segment, ":") {
+			continue
+		}
+		segments[i] = url.PathEscape(segment)
+	}
+	location := strings.Join(segments, "/")
+	if filepath.IsAbs(exportPath) {
+		if !strings.HasPrefix(location, "/") {
+			location = "/" + location
+		}
+		return "file://" + location
+	}
+	return location
+}
+
+// exportDisplayName 曲目的显示名称 艺术家 - 标题 标签缺失时使用文件名
+func exportDisplayName(meta util.MP3MetaInfo) string {
+	switch {
+	case meta.Artist != "" && meta.Title != "":
+		return meta.Artist + " - " + meta.Title
+	case meta.Title != "":
+		return meta.Title
+	default:
+		return strings.TrimSuffix(meta.FileName, filepath.Ext(meta.FileName))
+	}
+}
+
+// writeM3U8 扩展M3U格式 UTF-8编码
+func writeM3U8(w io.Writer, list exportList, options exportOptions) error {
+	var builder strings.Builder
+	builder.WriteString("#EXTM3U\n")
+	builder.WriteString("#PLAYLIST:" + list.Name + "\n")
+	for _, track := range list.Tracks {
+		//时长未知时为-1
+		seconds := -1
+		if track.Meta.Duration > 0 {
+			seconds = (track.Meta.Duration + 500) / 1000
+		}
+		builder.WriteString(fmt.Sprintf("#EXTINF:%d,%s\n", seconds, exportDisplayName(track.Meta)))
+		builder.WriteString(options.exportPath(track.Path) + "\n")
+	}
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// xspfPlaylist XSPF播放列表
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+// xspfTrack XSPF中的一首曲目
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	//毫秒
+	Duration int `xml:"duration,omitempty"`
+}
+
+// writeXSPF XSPF格式
+func writeXSPF(w io.Writer, list exportList, options exportOptions) error {
+	playlist := xspfPlaylist{
+		Version: "1",
+		Xmlns:   "http://xspf.org/ns/0/",
+		Title:   list.Name,
+		Tracks:  make([]xspfTrack, 0, len(list.Tracks)),
+	}
+	for _, track := range list.Tracks {
+		playlist.Tracks = append(playlist.Tracks, xspfTrack{
+			Location: options.exportLocation(track.Path),
+			Title:    track.Meta.Title,
+			Creator:  track.Meta.Artist,
+			Album:    track.Meta.Album,
+			Duration: track.Meta.Duration,
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(playlist); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeCSV CSV表格 带UTF-8 BOM 否则excel打开中文会乱码
+func writeCSV(w io.Writer, list exportList, options exportOptions) error {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"PlayList", "Position", "Title", "Artist", "Album", "Duration", "FileName", "Path"})
+	for i, track := range list.Tracks {
+		duration := ""
+		if track.Meta.Duration > 0 {
+			duration = strconv.Itoa((track.Meta.Duration + 500) / 1000)
+		}
+		_ = writer.Write([]string{
+			track.Meta.PlayListName,
+			strconv.Itoa(i + 1),
+			track.Meta.Title,
+			track.Meta.Artist,
+			track.Meta.Album,
+			duration,
+			track.Meta.FileName,
+			options.exportPath(track.Path),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// serveExport 下载导出文件 /export/:name name为歌单名或uncategorized
+// ?format=m3u8/xspf/csv(默认m3u8) ?absolute=0时写入相对于spotify_export的路径(默认绝对路径)
+func serveExport(c *gin.Context) {
+	format := c.DefaultQuery("format", exportM3U8)
+	if !isExportFormat(format) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "未知的导出格式: " + format})
+		return
+	}
+	name := c.Param("name")
+	var list exportList
+	if name == uncategorizedExportName {
+		uncategorizedList, ok := collectUncategorizedExport()
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"message": "没有待分类的曲目"})
+			return
+		}
+		list = uncategorizedList
+	} else {
+		//使用分类时已查询到的歌单ID 不再查询所有歌单
+		var sp *spotify.Client
+		playLists := make(map[string]spotify.SimplePlaylist)
+		if id, ok := playListMap[name]; ok && getSessionToken() != nil {
+			sp = newSessionClient(c.Request.Context())
+			playLists[name] = spotify.SimplePlaylist{ID: id, Name: name}
+		}
+		lists := collectPlayListExports(c.Request.Context(), sp, playLists, []string{name})
+		if len(lists) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"message": "歌单文件夹不存在: " + name})
+			return
+		}
+		list = lists[0]
+	}
+	options := exportOptions{format: format, absolute: c.Query("absolute") != "0", baseDir: defaultExportPath()}
+	fileName := util.SanitizeFileName(list.Name) + "." + format
+	c.Header("Content-Disposition", "attachment; filename*=UTF-8''"+url.PathEscape(fileName))
+	contentTypes := map[string]string{
+		exportM3U8: "audio/x-mpegurl; charset=utf-8",
+		exportXSPF: "application/xspf+xml; charset=utf-8",
+		exportCSV:  "text/csv; charset=utf-8",
+	}
+	c.Header("Content-Type", contentTypes[format])
+	c.Status(http.StatusOK)
+	if err := writeExport(c.Writer, list, options); err != nil {
+		fmt.Println("导出失败: ", err)
+	}
+}
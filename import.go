@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 导入条目的处理方式
+const (
+	//从外部复制到歌单文件夹
+	importCopied = "copied"
+	//从外部移动到歌单文件夹
+	importMoved = "moved"
+	//曲库中其他歌单文件夹的文件 记录多歌单归属 不复制
+	importMembership = "membership"
+	//已在歌单文件夹中
+	importExisting = "existing"
+	//播放列表中重复的条目
+	importDuplicate = "duplicate"
+)
+
+// 按元信息匹配到多首曲目 无法确定是哪一首
+const resolvedAmbiguous = "ambiguous"
+
+// importedEntry 一个已解析的条目
+type importedEntry struct {
+	//播放列表中的位置
+	Location string
+	//解析到的文件
+	Source string
+	//解析方式 path: 按路径 metadata: 按元信息匹配曲库
+	ResolvedBy string
+	//处理方式 copied/moved/membership/existing/duplicate
+	Action string
+	//文件在歌单文件夹中的路径 归属记录时为文件实际所在的路径
+	Dest string `json:",omitempty"`
+	//处理失败的原因
+	Error string `json:",omitempty"`
+}
+
+// importReport 导入报告 写入import_report.json
+type importReport struct {
+	//导入的播放列表文件
+	Source string
+	//导入到的歌单
+	PlayList string
+	//导入时间
+	Time string
+	//已解析的条目
+	Resolved []importedEntry
+	//无法解析的条目
+	Unresolved []util.M3UEntry
+}
+
+// runImport 把M3U/M3U8播放列表导入为spotify_local中的歌单文件夹
+// 条目按路径找到文件 找不到时按元信息在曲库中匹配
+// 外部文件默认复制到歌单文件夹(-move时移动) 曲库中其他歌单文件夹的文件只记录多歌单归属
+// -name: 歌单名 默认为播放列表文件名 -dry-run: 只打印 -create-playlist: 在spotify上创建同名歌单
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	name := flags.String("name", "", "歌单名 默认为播放列表的文件名")
+	move := flags.Bool("move", false, "移动外部文件 默认复制")
+	dryRun := flags.Bool("dry-run", false, "只打印解析结果 不复制或移动文件")
+	createPlayList := flags.Bool("create-playlist", false, "在spotify上创建同名歌单(已存在时跳过)")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("用法: import [-name 歌单名] [-move] [-dry-run] [-create-playlist] <播放列表.m3u8>")
+		os.Exit(1)
+	}
+	m3uPath := flags.Arg(0)
+	data, err := os.ReadFile(m3uPath)
+	if err != nil {
+		fmt.Println("无法读取播放列表: ", err)
+		os.Exit(1)
+	}
+	playListName := *name
+	if playListName == "" {
+		playListName = strings.TrimSuffix(filepath.Base(m3uPath), filepath.Ext(m3uPath))
+	}
+	playListName = util.SanitizeFileName(playListName)
+
+	entries := util.ParseM3U(data)
+	library := scanLibrary(spotifyLocalPath).Tracks
+	getLibraryIndex().save()
+	report := importReport{
+		Source:     m3uPath,
+		PlayList:   playListName,
+		Time:       time.Now().Format(time.DateTime),
+		Resolved:   make([]importedEntry, 0),
+		Unresolved: make([]util.M3UEntry, 0),
+	}
+	targetDir := filepath.Join(spotifyLocalPath, playListName)
+	if !*dryRun {
+		if err := os.MkdirAll(targetDir, os.ModeDir); err != nil {
+			fmt.Println("无法创建歌单文件夹: ", err)
+			os.Exit(1)
+		}
+	}
+	imported := make(map[string]bool)
+	memberships := make([]trackMembership, 0)
+	originals := linkedOriginals()
+	for _, entry := range entries {
+		source, resolvedBy := resolveImportEntry(entry, filepath.Dir(m3uPath), library)
+		//链接方式暂存的文件 以原文件为准
+		source = linkedOriginal(originals, source)
+		if source == "" {
+			report.Unresolved = append(report.Unresolved, entry)
+			if resolvedBy == resolvedAmbiguous {
+				fmt.Println("无法解析(曲库中有多首匹配的曲目): ", entry.Location)
+			} else {
+				fmt.Println("无法解析: ", entry.Location)
+			}
+			continue
+		}
+		item := importedEntry{Location: entry.Location, Source: source, ResolvedBy: resolvedBy}
+		switch folder, inLibrary := libraryFolder(source); {
+		case imported[source]:
+			item.Action = importDuplicate
+		case inLibrary && folder == inboxStagingName:
+			//收件箱中的曲目还没有歌单 导入即分类 和加入歌单一样移到歌单文件夹
+			item.Action = importMoved
+			if !*dryRun {
+				item.Action, item.Dest, err = importFile(source, filepath.Join(targetDir, filepath.Base(source)), true)
+				if err != nil {
+					item.Error = err.Error()
+				}
+			}
+		case inLibrary && folder == playListName:
+			item.Action, item.Dest = importExisting, source
+		case inLibrary:
+			//文件只放在一个歌单文件夹中 其他歌单通过membership.json收录
+			item.Action, item.Dest = importMembership, source
+			memberships = append(memberships, trackMembership{
+				Track:     libraryTrack(source, library),
+				Folder:    folder,
+				PlayLists: []string{playListName},
+			})
+		case *dryRun && *move:
+			item.Action = importMoved
+		case *dryRun:
+			item.Action = importCopied
+		default:
+			item.Action, item.Dest, err = importFile(source, filepath.Join(targetDir, filepath.Base(source)), *move)
+			if err != nil {
+				item.Error = err.Error()
+			}
+		}
+		imported[source] = true
+		report.Resolved = append(report.Resolved, item)
+		fmt.Printf("[%v/%v] %v => %v\n", item.ResolvedBy, item.Action, entry.Location, source)
+		if item.Error != "" {
+			fmt.Println("  失败: ", item.Error)
+		}
+	}
+	fmt.Printf("共%d个条目, 已解析%d个, 无法解析%d个\n", len(entries), len(report.Resolved), len(report.Unresolved))
+	if *dryRun {
+		fmt.Println("以上为预览, 去掉-dry-run参数执行导入")
+		return
+	}
+	if len(memberships) != 0 {
+		addMemberships(memberships)
+	}
+	saveImportReport(report)
+	if *createPlayList {
+		createSpotifyPlayList(playListName)
+	}
+}
+
+// resolveImportEntry 解析条目对应的文件 返回文件路径和解析方式 找不到时返回空字符串
+// 按元信息匹配到多首曲目时(如标题为Intro 又没有艺术家)不猜测 路径为空 解析方式为ambiguous
+func resolveImportEntry(entry util.M3UEntry, baseDir string, library []util.MP3MetaInfo) (string, string) {
+	location := util.ResolveM3ULocation(entry.Location, baseDir)
+	if location != "" && strings.HasSuffix(strings.ToLower(location), ".mp3") {
+		if info, err := os.Stat(location); err == nil && !info.IsDir() {
+			if absPath, err := filepath.Abs(location); err == nil {
+				location = absPath
+			}
+			return location, "path"
+		}
+	}
+	//文件不在记录的位置(如在其他电脑上导出) 按#EXTINF或文件名推断的元信息匹配曲库
+	fields := entry.Fields()
+	if fields.Title == "" && location != "" {
+		name := strings.TrimSuffix(filepath.Base(location), filepath.Ext(location))
+		fields, _ = util.InferTagFields(name, util.TagFields{}, fileNamePatterns)
+	}
+	if fields.Title == "" {
+		return "", ""
+	}
+	candidate := util.MP3MetaInfo{Title: fields.Title, Artist: fields.Artist, Album: fields.Album, Duration: entry.Duration}
+	matches := make([]util.MP3MetaInfo, 0)
+	for _, track := range library {
+		//播放列表中通常没有专辑 没有艺术家时只比较标题和时长
+		compared := candidate
+		if compared.Album == "" {
+			compared.Album = track.Album
+		}
+		if compared.Artist == "" {
+			compared.Artist = track.Artist
+		}
+		if isSameTrack(compared, track) {
+			matches = append(matches, track)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", ""
+	case 1:
+		return filepath.Join(spotifyLocalPath, matches[0].PlayListName, matches[0].FileName), "metadata"
+	default:
+		return "", resolvedAmbiguous
+	}
+}
+
+// libraryFolder 文件所在的歌单文件夹 第二个返回值表示文件是否在spotify_local或暂存区的歌单文件夹中
+// 暂存区的文件分类后会回到spotify_local 同样视为曲库中的文件 收件箱中的文件返回_inbox
+func libraryFolder(path string) (string, bool) {
+	for _, root := range []string{spotifyLocalPath, spotifyLocalTempPath} {
+		rootPath, err := filepath.Abs(root)
+		if err != nil || !util.IsSubPath(rootPath, path) {
+			continue
+		}
+		rel, err := filepath.Rel(rootPath, path)
+		if err != nil {
+			return "", false
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 2 {
+			//不在歌单文件夹中(直接放在spotify_local下或更深的子文件夹)
+			return "", false
+		}
+		return parts[0], true
+	}
+	return "", false
+}
+
+// libraryTrack 曲库中文件的元信息 曲库扫描结果中没有时(如暂存区的文件)单独解析 解析失败时只有文件名
+func libraryTrack(path string, library []util.MP3MetaInfo) util.MP3MetaInfo {
+	folder, fileName := filepath.Base(filepath.Dir(path)), filepath.Base(path)
+	for _, track := range library {
+		if track.PlayListName == folder && track.FileName == fileName {
+			return track
+		}
+	}
+	if info, err := os.Stat(path); err == nil {
+		meta, err := getLibraryIndex().extract(path, info)
+		if meta, err = inferMissingTags(path, meta, err); err == nil {
+			return meta
+		}
+	}
+	return util.MP3MetaInfo{PlayListName: folder, FileName: fileName}
+}
+
+// importFile 把外部文件复制或移动到歌单文件夹 返回处理方式和最终路径
+// 复制时目标已有内容相同的文件则跳过 不同时加后缀 移动时按ConflictPolicy处理
+func importFile(source string, dest string, move bool) (string, string, error) {
+	if move {
+		finalPath, err := moveFile(source, dest)
+		return importMoved, finalPath, err
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		if isIdenticalFile(source, dest) {
+			return importExisting, dest, nil
+		}
+		dest = uniquePath(dest)
+	}
+	return importCopied, dest, copyFileVerified(source, dest)
+}
+
+// saveImportReport 写入import_report.json
+func saveImportReport(report importReport) {
+	reportFile, err := os.Create(filepath.Join(spotifyConfigBasePath, "import_report.json"))
+	if err != nil {
+		fmt.Println("无法创建import_report.json: ", err)
+		return
+	}
+	defer reportFile.Close()
+	encoder := json.NewEncoder(reportFile)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+	if len(report.Unresolved) != 0 {
+		fmt.Println("无法解析的条目已写入import_report.json")
+	}
+}
+
+// createSpotifyPlayList 在spotify上创建同名的私有歌单 下次运行时按歌单文件夹分类
+// Web API无法添加本地曲目 需要在客户端中把文件夹中的曲目加入歌单
+func createSpotifyPlayList(playListName string) {
+	sp := newTokenClient()
+	if sp == nil {
+		return
+	}
+	ctx := context.Background()
+	user, err := sp.CurrentUser(ctx)
+	if err != nil {
+		fmt.Println("无法连接spotify: ", err)
+		return
+	}
+	for _, playList := range getAllPlayLists(sp, ctx, user.ID) {
+		if playList.Name == playListName {
+			fmt.Println("spotify上已有同名歌单: ", playListName)
+			return
+		}
+	}
+	if _, err := sp.CreatePlaylistForUser(ctx, user.ID, playListName, "", false, false); err != nil {
+		fmt.Println("创建歌单失败: ", err)
+		return
+	}
+	fmt.Printf("已在spotify上创建歌单: %v, 下次运行时文件夹中的曲目会移入spotify_local_temp等待加入歌单\n", playListName)
+}
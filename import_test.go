@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+func TestResolveImportEntry(t *testing.T) {
+	appConf = &appConfig{DurationTolerance: 3}
+	fileNamePatterns = compileFileNamePatterns([]string{"{artist} - {title}"})
+	library := []util.MP3MetaInfo{
+		{PlayListName: "A", FileName: "intro1.mp3", Title: "Intro", Artist: "Daft Punk", Album: "X", Duration: 60000},
+		{PlayListName: "B", FileName: "intro2.mp3", Title: "Intro", Artist: "The xx", Album: "Y", Duration: 90000},
+		{PlayListName: "A", FileName: "晴天.mp3", Title: "晴天", Artist: "周杰伦", Album: "叶惠美", Duration: 269000},
+		{PlayListName: "C", FileName: "晴天 (Live).mp3", Title: "晴天", Artist: "周杰伦", Album: "叶惠美", Duration: 330000},
+	}
+	inLibrary := func(folder, fileName string) string {
+		return filepath.Join(spotifyLocalPath, folder, fileName)
+	}
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.mp3")
+	if err := os.WriteFile(existing, []byte("mp3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		entry      util.M3UEntry
+		want       string
+		resolvedBy string
+	}{
+		{
+			name:       "按路径找到",
+			entry:      util.M3UEntry{Location: "existing.mp3", Title: "晴天"},
+			want:       existing,
+			resolvedBy: "path",
+		},
+		{
+			name:       "标题相同没有艺术家和时长",
+			entry:      util.M3UEntry{Location: "missing/Intro.mp3", Title: "Intro"},
+			resolvedBy: resolvedAmbiguous,
+		},
+		{
+			name:       "标题相同按时长区分",
+			entry:      util.M3UEntry{Location: "missing/Intro.mp3", Title: "Intro", Duration: 91000},
+			want:       inLibrary("B", "intro2.mp3"),
+			resolvedBy: "metadata",
+		},
+		{
+			name:       "标题相同按艺术家区分",
+			entry:      util.M3UEntry{Location: "missing/Intro.mp3", Title: "Daft Punk - Intro"},
+			want:       inLibrary("A", "intro1.mp3"),
+			resolvedBy: "metadata",
+		},
+		{
+			name:       "同一首歌的不同版本没有时长",
+			entry:      util.M3UEntry{Location: "missing/晴天.mp3", Title: "周杰伦 - 晴天"},
+			resolvedBy: resolvedAmbiguous,
+		},
+		{
+			name:       "同一首歌的不同版本按时长区分",
+			entry:      util.M3UEntry{Location: "missing/晴天.mp3", Title: "周杰伦 - 晴天", Duration: 329000},
+			want:       inLibrary("C", "晴天 (Live).mp3"),
+			resolvedBy: "metadata",
+		},
+		{
+			name:  "时长相差太多",
+			entry: util.M3UEntry{Location: "missing/晴天.mp3", Title: "周杰伦 - 晴天", Duration: 200000},
+		},
+		{
+			name:       "没有标题时从文件名推断",
+			entry:      util.M3UEntry{Location: "missing/周杰伦 - 晴天.mp3", Duration: 269000},
+			want:       inLibrary("A", "晴天.mp3"),
+			resolvedBy: "metadata",
+		},
+		{
+			name:  "曲库中没有",
+			entry: util.M3UEntry{Location: "missing/七里香.mp3", Title: "周杰伦 - 七里香"},
+		},
+	}
+	for _, test := range tests {
+		got, resolvedBy := resolveImportEntry(test.entry, dir, library)
+		if got != test.want || resolvedBy != test.resolvedBy {
+			t.Errorf("%v: resolveImportEntry = %q, %q, want %q, %q", test.name, got, resolvedBy, test.want, test.resolvedBy)
+		}
+	}
+}
+
+func TestLibraryFolder(t *testing.T) {
+	libraryPath, _ := filepath.Abs(spotifyLocalPath)
+	tempPath, _ := filepath.Abs(spotifyLocalTempPath)
+	tests := []struct {
+		path      string
+		folder    string
+		inLibrary bool
+	}{
+		{path: filepath.Join(libraryPath, "A", "a.mp3"), folder: "A", inLibrary: true},
+		//暂存区的文件同样属于曲库
+		{path: filepath.Join(tempPath, "B", "b.mp3"), folder: "B", inLibrary: true},
+		{path: filepath.Join(tempPath, inboxStagingName, "c.mp3"), folder: inboxStagingName, inLibrary: true},
+		{path: filepath.Join(libraryPath, "a.mp3")},
+		{path: filepath.Join(libraryPath, "A", "sub", "a.mp3")},
+		{path: filepath.Join(filepath.Dir(libraryPath), "music", "a.mp3")},
+	}
+	for _, test := range tests {
+		folder, inLibrary := libraryFolder(test.path)
+		if folder != test.folder || inLibrary != test.inLibrary {
+			t.Errorf("libraryFolder(%q) = %q, %v, want %q, %v", test.path, folder, inLibrary, test.folder, test.inLibrary)
+		}
+	}
+}
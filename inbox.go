@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 收件箱中未命中规则的曲目在spotify_local_temp中的暂存文件夹
+const inboxStagingName = "_inbox"
+
+// matches 判断曲目是否满足规则的所有条件
+func (rule *routeRule) matches(mp3 util.MP3MetaInfo, frames map[string]string) bool {
+	if rule.PlayList == "" {
+		return false
+	}
+	year := frames["TYER"]
+	if year == "" && len(frames["TDRC"]) >= 4 {
+		year = frames["TDRC"][:4]
+	}
+	conditions := [][2]string{
+		{rule.Artist, mp3.Artist},
+		{rule.Album, mp3.Album},
+		{rule.Genre, frames["TCON"]},
+		{rule.Year, year},
+		{rule.FileName, mp3.FileName},
+	}
+	if rule.Frame != "" {
+		//只配置帧ID时 要求该帧存在
+		value, ok := frames[rule.Frame]
+		if !ok {
+			return false
+		}
+		conditions = append(conditions, [2]string{rule.FrameValue, value})
+	}
+	for _, condition := range conditions {
+		if condition[0] != "" && !util.MatchGlob(condition[0], condition[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchRule 返回第一条命中的规则
+func matchRule(mp3 util.MP3MetaInfo, frames map[string]string) *routeRule {
+	for i := range appConf.Rules {
+		if appConf.Rules[i].matches(mp3, frames) {
+			return &appConf.Rules[i]
+		}
+	}
+	return nil
+}
+
+// processInbox 按规则将收件箱中的曲目分发到spotify_local/<歌单> 未命中的曲目移入暂存区
+func processInbox() {
+	inboxFiles := make([]string, 0)
+	_ = filepath.Walk(spotifyInboxPath, func(path string, info fs.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(strings.ToLower(info.Name()), ".mp3") {
+			inboxFiles = append(inboxFiles, path)
+		}
+		return nil
+	})
+	if len(inboxFiles) == 0 {
+		return
+	}
+	routeLog, err := os.OpenFile(filepath.Join(spotifyConfigBasePath, "route.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Println("无法打开路由日志: ", err)
+		return
+	}
+	defer routeLog.Close()
+	for _, path := range inboxFiles {
+		mp3, err := util.ExtractMp3FromPath(path)
+		if err != nil {
+			fmt.Println("无法解析收件箱曲目: ", path)
+			continue
+		}
+		frames, _ := util.ReadTextFrames(path)
+		ruleName := "-"
+		destDir := filepath.Join(spotifyLocalTempPath, inboxStagingName)
+		if rule := matchRule(mp3, frames); rule != nil {
+			ruleName = rule.Name
+			destDir = filepath.Join(spotifyLocalPath, rule.PlayList)
+		}
+		finalPath := filepath.Join(destDir, mp3.FileName)
+		err = os.MkdirAll(destDir, 0755)
+		if err == nil {
+			finalPath, err = moveFile(path, finalPath)
+		}
+		decision := fmt.Sprintf("%s\t%s\trule=%s\t=> %s", time.Now().Format(time.DateTime), path, ruleName, finalPath)
+		if err != nil {
+			decision += "\t失败: " + err.Error()
+		}
+		fmt.Println("收件箱: ", decision)
+		_, _ = fmt.Fprintln(routeLog, decision)
+	}
+}
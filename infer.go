@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 从文件名推断标签的模板 由配置中的FileNamePatterns编译而来
+var fileNamePatterns = make([]*util.FileNamePattern, 0)
+
+// compileFileNamePatterns 编译配置中的文件名模板 无效的模板跳过
+func compileFileNamePatterns(patterns []string) []*util.FileNamePattern {
+	res := make([]*util.FileNamePattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		fileNamePattern, err := util.ParseFileNamePattern(pattern)
+		if err != nil {
+			fmt.Println("忽略无效的文件名模板: ", err)
+			continue
+		}
+		res = append(res, fileNamePattern)
+	}
+	return res
+}
+
+// inferMissingTags 用文件名补全标签中为空的字段(包括没有ID3标签的文件)
+func inferMissingTags(path string, meta util.MP3MetaInfo, err error) (util.MP3MetaInfo, error) {
+	if err != nil {
+		return meta, err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	//有标题时spotify直接使用标签 不会解析文件名 这里保持一致 否则两边推断出的结果对不上
+	if meta.Title != "" {
+		return meta, nil
+	}
+	fields, _ := util.InferTagFields(name, util.TagFieldsOf(meta), fileNamePatterns)
+	if len(util.DiffTagFields(util.TagFieldsOf(meta), fields)) != 0 {
+		meta.Title, meta.Artist, meta.Album = fields.Title, fields.Artist, fields.Album
+		meta.Inferred = true
+	}
+	return meta, nil
+}
+
+// inferRemoteTrack 补全spotify上没有艺术家的本地曲目
+// 没有标签的文件在spotify中以文件名(不含扩展名)作为标题 用同样的模板解析
+func inferRemoteTrack(track util.MP3MetaInfo) util.MP3MetaInfo {
+	if track.Artist != "" {
+		return track
+	}
+	fields, matched := util.InferTagFields(track.Title, util.TagFields{Album: track.Album}, fileNamePatterns)
+	if matched {
+		track.Title, track.Artist, track.Album = fields.Title, fields.Artist, fields.Album
+		track.Inferred = true
+	}
+	return track
+}
+
+// writeInferredTags 把从文件名推断出的字段写入标签 写入前备份原始标签 root为曲目所在的根目录
+func writeInferredTags(root string, data map[string][]util.MP3MetaInfo) {
+	changes := make([]tagChange, 0)
+	originals := linkedOriginals()
+	for _, playListName := range sortedKeys(data) {
+		for _, track := range data[playListName] {
+			if !track.Inferred || track.LinkedFrom != "" {
+				continue
+			}
+			path := filepath.Join(root, playListName, track.FileName)
+			if _, ok := originals[filepath.Clean(path)]; ok {
+				//链接方式暂存的曲目 原文件在歌单文件夹中 随歌单文件夹一起写入
+				continue
+			}
+			changes = append(changes, tagChange{
+				Path: path,
+				New:  util.TagFieldsOf(track),
+			})
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	backupPath, err := backupTags(changes)
+	if err != nil {
+		fmt.Println("备份标签失败, 不写入推断的标签: ", err)
+		return
+	}
+	for _, change := range changes {
+		if err := writeTagDiffs(change.Path, util.DiffTagFields(util.TagFields{}, change.New)); err != nil {
+			fmt.Printf("写入标签失败: %v: %v\n", change.Path, err)
+			continue
+		}
+		fmt.Printf("已写入推断的标签: %v: %v - %v\n", change.Path, change.New.Artist, change.New.Title)
+	}
+	fmt.Println("原始标签已备份到: ", backupPath)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 索引格式的版本 元信息增加字段后递增 旧版本的条目会重新解析
+const libraryIndexVersion = 1
+
+// libraryEntry 曲库索引中的一个文件
+type libraryEntry struct {
+	//索引格式的版本
+	Version int
+	//文件大小
+	Size int64
+	//修改时间(纳秒)
+	ModTime int64
+	//文件快速指纹 见util.QuickHash
+	Hash string
+	//解析时优先使用的旧编码 配置修改后需要重新解析
+	Encoding string
+	//解析好的元信息
+	Meta util.MP3MetaInfo
+}
+
+// libraryIndex 持久化的曲库索引 大小和修改时间没变的文件不再重复解析标签
+type libraryIndex struct {
+	mutex sync.Mutex
+	//键为文件路径
+	entries map[string]*libraryEntry
+	//指纹到路径的映射 用于识别重命名/移动过的文件
+	byHash map[string]string
+}
+
+var (
+	//全局的曲库索引
+	libIndex *libraryIndex
+	//保证曲库索引只加载一次
+	libIndexOnce sync.Once
+)
+
+// 索引文件路径
+func libraryIndexPath() string {
+	return filepath.Join(spotifyConfigBasePath, "library_index.json")
+}
+
+// getLibraryIndex 获取曲库索引 第一次调用时从磁盘加载
+func getLibraryIndex() *libraryIndex {
+	libIndexOnce.Do(func() {
+		libIndex = &libraryIndex{
+			entries: make(map[string]*libraryEntry),
+			byHash:  make(map[string]string),
+		}
+		indexFile, err := os.Open(libraryIndexPath())
+		if err != nil {
+			return
+		}
+		defer indexFile.Close()
+		if err := json.NewDecoder(indexFile).Decode(&libIndex.entries); err != nil {
+			fmt.Println("曲库索引解析失败, 将重新建立: ", err)
+			libIndex.entries = make(map[string]*libraryEntry)
+			return
+		}
+		for path, entry := range libIndex.entries {
+			libIndex.byHash[entry.Hash] = path
+		}
+	})
+	return libIndex
+}
+
+// isCurrent 条目是否由当前版本 当前编码配置解析 否则需要重新解析
+func (entry *libraryEntry) isCurrent() bool {
+	return entry.Version == libraryIndexVersion && entry.Encoding == util.LegacyEncoding()
+}
+
+// extract 获取文件的元信息 优先使用索引
+func (index *libraryIndex) extract(path string, info fs.FileInfo) (util.MP3MetaInfo, error) {
+	index.mutex.Lock()
+	entry, ok := index.entries[path]
+	index.mutex.Unlock()
+	if ok && entry.Size == info.Size() && entry.ModTime == info.ModTime().UnixNano() && entry.isCurrent() {
+		return entry.Meta, nil
+	}
+
+	hash, err := util.QuickHash(path)
+	if err != nil {
+		return util.MP3MetaInfo{}, err
+	}
+	var meta util.MP3MetaInfo
+	index.mutex.Lock()
+	knownPath, renamed := index.byHash[hash]
+	renamed = renamed && index.entries[knownPath].isCurrent()
+	if renamed {
+		//内容相同的文件已经解析过 只需要更新路径相关的字段
+		meta = index.entries[knownPath].Meta
+	}
+	index.mutex.Unlock()
+	parentDirPath, fileName := filepath.Split(path)
+	if renamed {
+		meta.PlayListName = filepath.Base(parentDirPath)
+		meta.FileName = fileName
+	} else {
+		meta, err = util.ExtractMp3FromPath(path)
+		if err != nil {
+			return util.MP3MetaInfo{}, err
+		}
+	}
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.entries[path] = &libraryEntry{
+		Version:  libraryIndexVersion,
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UnixNano(),
+		Hash:     hash,
+		Encoding: util.LegacyEncoding(),
+		Meta:     meta,
+	}
+	index.byHash[hash] = path
+	return meta, nil
+}
+
+// save 清理已不存在的文件后写入磁盘
+func (index *libraryIndex) save() {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	for path, entry := range index.entries {
+		if _, err := os.Stat(path); err != nil {
+			delete(index.entries, path)
+			if index.byHash[entry.Hash] == path {
+				delete(index.byHash, entry.Hash)
+			}
+		}
+	}
+	indexFile, err := os.Create(libraryIndexPath())
+	if err != nil {
+		fmt.Println("无法写入曲库索引: ", err)
+		return
+	}
+	defer indexFile.Close()
+	_ = json.NewEncoder(indexFile).Encode(index.entries)
+}
@@ -1,19 +1,20 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"embed"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -30,20 +31,10 @@ var (
 	spotifyClientSecret string
 	//权限
 	scopes []string
-	//会话标识符
-	state string
 	//gin本地监听端口 默认9999
 	listenPort int
 	//重定向URL
 	redirectURL string
-	//协程同步对象
-	wg = &sync.WaitGroup{}
-	//授权协程通道
-	authChan = make(chan struct{})
-	//服务停止信号
-	stopChan = make(chan struct{})
-	//存储token的通道
-	tokenChan = make(chan *oauth2.Token)
 	//认证器
 	auth *spotifyauth.Authenticator
 	//项目配置根目录
@@ -56,8 +47,20 @@ var (
 	spotifyLocalPath string
 	//spotify本地临时文件(存放未分类mp3)所在目录
 	spotifyLocalTempPath string
+	//收件箱 新下载的曲目放在这里 按规则自动分发到歌单文件夹
+	spotifyInboxPath string
+	//隔离文件夹 存放重复或损坏的文件
+	spotifyQuarantinePath string
+	//用户配置
+	appConf *appConfig
 	//spotify客户端是否需要重启
 	needSpotifyRecover = false
+	//监听模式 持续监听spotify_local中新下载的曲目
+	watchMode bool
+	//确认后从歌单中移除本地文件已不存在的曲目
+	pruneOrphansMode bool
+	//将无法解析的文件移入隔离文件夹
+	quarantineUnreadableMode bool
 	//go:embed static/index.html
 	htmlFile embed.FS
 	//go:embed static/js/jsonview.js
@@ -104,7 +107,6 @@ func init() {
 		spotifyauth.ScopePlaylistModifyPrivate,
 		spotifyauth.ScopePlaylistModifyPublic,
 	}
-	state = util.GenerateRandString(10)
 	//home目录
 	homeDir, err := os.UserHomeDir()
 	if err == nil {
@@ -115,6 +117,11 @@ func init() {
 			os.Exit(1)
 		}
 		tokenPath = filepath.Join(spotifyConfigBasePath, "Token.json")
+		appConf = loadAppConfig(filepath.Join(spotifyConfigBasePath, "config.json"))
+		if err := util.SetLegacyEncoding(appConf.LegacyEncoding); err != nil {
+			fmt.Println("LegacyEncoding配置无效, 使用gbk: ", err)
+		}
+		fileNamePatterns = compileFileNamePatterns(appConf.FileNamePatterns)
 
 	} else {
 		fmt.Println("获取用户目录错误")
@@ -160,6 +167,14 @@ func init() {
 	//如果临时文件夹不存在 则创建
 	//os.RemoveAll(spotifyLocalTempPath)
 	os.MkdirAll(spotifyLocalTempPath, os.ModeDir)
+	//处理收件箱文件夹
+	spotifyInboxPath = appConf.InboxPath
+	if spotifyInboxPath == "" {
+		spotifyInboxPath = filepath.Join(currDir, "spotify_inbox")
+	}
+	os.MkdirAll(spotifyInboxPath, os.ModeDir)
+	//隔离文件夹在需要时才创建
+	spotifyQuarantinePath = filepath.Join(currDir, "spotify_quarantine")
 }
 
 // 用默认浏览器打开URL
@@ -186,7 +201,7 @@ func openAuthorizationURL() {
 
 func initOauthConfig(clientID string, clientSecret string, port int) {
 	//如果tokenPath不存在 就要求用户输入这两个值 ; 如果存在 在反序列号token.json成功之后 将对应的值设置到客户端ID,密钥,端口中
-	reader := bufio.NewReader(os.Stdin)
+	reader := stdinReader
 	if clientID != "" {
 		spotifyClientID = clientID
 	} else {
@@ -231,13 +246,16 @@ func initOauthConfig(clientID string, clientSecret string, port int) {
 				redirectURL = fmt.Sprintf("http://127.0.0.1:%d/callback", listenPort)
 				break
 			}
-			listenPort, err := strconv.Atoi(inputPort)
+			inputPortNum, err := strconv.Atoi(inputPort)
 			if err != nil {
 				fmt.Println("端口必须为整数!")
 				continue
-			} else if util.IsPortInUse(listenPort) {
-				fmt.Printf("端口: %v已被占用,请更换!", listenPort)
+			} else if util.IsPortInUse(inputPortNum) {
+				fmt.Printf("端口: %v已被占用,请更换!\n", inputPortNum)
+				continue
 			}
+			//端口会写入token.json 之后的回调地址和页面地址都基于该端口
+			listenPort = inputPortNum
 			redirectURL = fmt.Sprintf("http://127.0.0.1:%d/callback", listenPort)
 			break
 		}
@@ -309,258 +327,220 @@ loop:
 	}
 }
 
-// 启动协程
+// 启动协程 读取已保存的token直接处理 没有token时打开授权URL 由/callback完成后续处理
 func boot() {
-	defer wg.Done()
-
-	select {
-	case <-authChan:
-		fmt.Println("授权协程已准备好")
-		//尝试打开存储token的json文件
-		tokenFile, err := os.Open(tokenPath)
-		if err == nil {
-			defer tokenFile.Close()
-			//token存在  则读取token.json 反序列化到内存中  不用OAuth2授权
-			principal := new(spotifyPrincipal)
-			decoder := json.NewDecoder(tokenFile)
-			err := decoder.Decode(principal)
-			if err != nil {
-				fmt.Println("无法解码token.json: ", err)
-				os.Exit(1)
-			} else if principal.Token == nil {
-				fmt.Println("无效的token.json!")
-				os.Exit(1)
-			}
-			go func() {
-				tokenChan <- principal.Token
-			}()
-			spotifyClientID = principal.SpotifyClientID
-			spotifyClientSecret = principal.SpotifyClientSecret
-			listenPort = principal.Port
-			redirectURL = principal.getRedirectURL()
-			ctx := context.Background()
-			config := &oauth2.Config{
-				ClientID:     principal.SpotifyClientID,
-				ClientSecret: principal.SpotifyClientSecret,
-				RedirectURL:  principal.getRedirectURL(),
-				Scopes:       scopes,
-				Endpoint: oauth2.Endpoint{
-					AuthURL:  spotifyauth.AuthURL,
-					TokenURL: spotifyauth.TokenURL,
-				},
-			}
-			client := config.Client(ctx, principal.Token)
-			sp := spotify.New(client)
-			//直接进行业务处理
-			success := handle(ctx, sp)
-			if success {
-				//终止callback协程
-				stopChan <- struct{}{}
-				break
-			}
-		} else {
-			//. Token.json不存在
-			openAuthorizationURL()
-			break
-		}
+	//尝试打开存储token的json文件
+	principal, err := readPrincipal()
+	if err != nil {
+		//. Token.json不存在
+		openAuthorizationURL()
+		return
+	}
+	//token存在  则读取token.json 反序列化到内存中  不用OAuth2授权
+	setSessionToken(principal.Token)
+	redirectURL = principal.getRedirectURL()
+	ctx := context.Background()
+	config := &oauth2.Config{
+		ClientID:     principal.SpotifyClientID,
+		ClientSecret: principal.SpotifyClientSecret,
+		RedirectURL:  principal.getRedirectURL(),
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  spotifyauth.AuthURL,
+			TokenURL: spotifyauth.TokenURL,
+		},
+	}
+	client := config.Client(ctx, principal.Token)
+	sp := spotify.New(client)
+	//直接进行业务处理
+	if handle(ctx, sp) {
+		finishAuth()
 	}
 }
 
-// 授权协程
-func callback(server *http.Server) {
-	defer wg.Done()
-	//创建路由对象
-	router := gin.Default()
+// readPrincipal 读取token.json
+func readPrincipal() (*spotifyPrincipal, error) {
+	tokenFile, err := os.Open(tokenPath)
+	if err != nil {
+		return nil, err
+	}
+	defer tokenFile.Close()
+	principal := new(spotifyPrincipal)
+	decoder := json.NewDecoder(tokenFile)
+	err = decoder.Decode(principal)
+	if err != nil {
+		fmt.Println("无法解码token.json: ", err)
+		os.Exit(1)
+	} else if principal.Token == nil {
+		fmt.Println("无效的token.json!")
+		os.Exit(1)
+	}
+	return principal, nil
+}
 
-	// 注册路由
-	router.GET("/callback", func(c *gin.Context) {
-		//申请token
-		token := exchangeCodeForToken(c.Writer, c.Request)
-		if token == nil {
-			_, _ = c.Writer.WriteString("无法申请token!")
-			os.Exit(1)
-		}
-		go func() { tokenChan <- token }()
-		//os.Open()只能打开文件   os.Create()可以新建或覆写文件
-		tokenFile, err := os.Create(tokenPath)
-		if err != nil {
-			fmt.Println("无法创建token.json文件")
-			os.Exit(1)
-		}
-		encoder := json.NewEncoder(tokenFile)
-		err = encoder.Encode(spotifyPrincipal{
-			Token:               token,
-			SpotifyClientID:     spotifyClientID,
-			SpotifyClientSecret: spotifyClientSecret,
-			Port:                listenPort,
-		})
-		if err != nil {
-			fmt.Println("无法写入文件: ", err)
-			os.Exit(1)
-		}
-		err = tokenFile.Close()
-		if err != nil {
-			fmt.Println("无法关闭文件: ", err)
-			os.Exit(1)
-		}
-		client := auth.Client(c, token)
-		sp := spotify.New(client)
-		success := handle(c, sp)
-		if success {
-			stopChan <- struct{}{}
-		}
+// 授权回调 申请token后进行业务处理
+func handleCallback(c *gin.Context) {
+	//申请token
+	token := exchangeCodeForToken(c.Writer, c.Request)
+	if token == nil {
+		_, _ = c.Writer.WriteString("无法申请token!")
+		return
+	}
+	setSessionToken(token)
+	//os.Open()只能打开文件   os.Create()可以新建或覆写文件
+	tokenFile, err := os.Create(tokenPath)
+	if err != nil {
+		fmt.Println("无法创建token.json文件")
+		os.Exit(1)
+	}
+	encoder := json.NewEncoder(tokenFile)
+	err = encoder.Encode(spotifyPrincipal{
+		Token:               token,
+		SpotifyClientID:     spotifyClientID,
+		SpotifyClientSecret: spotifyClientSecret,
+		Port:                listenPort,
 	})
-	tokenFile, err := os.Open(tokenPath)
 	if err != nil {
-		//如果不存在客户端id 密钥和端口信息就
+		fmt.Println("无法写入文件: ", err)
+		os.Exit(1)
+	}
+	err = tokenFile.Close()
+	if err != nil {
+		fmt.Println("无法关闭文件: ", err)
+		os.Exit(1)
+	}
+	client := auth.Client(c, token)
+	sp := spotify.New(client)
+	if handle(c, sp) {
+		finishAuth()
+	}
+}
+
+// 加载客户端ID 密钥和端口信息
+func loadOauthConfig() {
+	principal, err := readPrincipal()
+	if err != nil {
+		//如果不存在客户端id 密钥和端口信息就要求用户输入
 		initOauthConfig(spotifyClientID, spotifyClientSecret, listenPort)
-	} else {
-		principal := new(spotifyPrincipal)
-		decoder := json.NewDecoder(tokenFile)
-		err := decoder.Decode(principal)
-		if err != nil {
-			fmt.Println("无法解码token.json: ", err)
-			os.Exit(1)
-		} else if principal.Token == nil {
-			fmt.Println("无效的token.json!")
-			os.Exit(1)
-		}
-		spotifyClientID = principal.SpotifyClientID
-		spotifyClientSecret = principal.SpotifyClientSecret
-		listenPort = principal.Port
-		_ = tokenFile.Close()
+		return
 	}
-	//绑定server
-	server.Handler = router
-	//绑定端口
-	server.Addr = ":" + strconv.Itoa(listenPort)
+	spotifyClientID = principal.SpotifyClientID
+	spotifyClientSecret = principal.SpotifyClientSecret
+	listenPort = principal.Port
+	redirectURL = principal.getRedirectURL()
+}
 
-	//启动server
+// startServer 监听端口并启动服务 监听失败时直接返回错误
+func startServer(router *gin.Engine) (*http.Server, error) {
+	listener, err := net.Listen("tcp", listenAddr())
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: router}
 	go func() {
-		//server.ListenAndServe()会阻塞 直到发生错误
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Println("服务器启动失败: ", err)
+		//server.Serve()会阻塞 直到发生错误
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Println("服务器运行失败: ", err)
 		}
 	}()
+	return server, nil
+}
 
-	// 服务器成功启动后延时1秒发送通知
-	time.Sleep(1 * time.Second)
-	fmt.Println("Auth服务成功启动!")
-
-	//服务器成功启动 通知业务协程已准备就绪
-	authChan <- struct{}{}
-
-	//等待终止信号
-	<-stopChan
-
-	//关闭服务器
+// 关闭服务器
+func shutdownServer(server *http.Server) {
 	if err := server.Shutdown(context.Background()); err != nil {
 		fmt.Println("服务器关闭失败: ", err)
 	}
 }
 
 func main() {
-	wg.Add(2)
-
-	server := &http.Server{}
-
+	flag.BoolVar(&watchMode, "watch", false, "监听spotify_local中新下载的曲目 增量加入待分类列表 按Ctrl+C结束")
+	flag.BoolVar(&pruneOrphansMode, "prune-orphans", false, "确认后从歌单中移除本地文件已不存在的本地曲目")
+	flag.BoolVar(&quarantineUnreadableMode, "quarantine-unreadable", false, "将无法解析(0字节,没有标签,被截断)的文件移入隔离文件夹")
+	flag.Parse()
+	if flag.NArg() > 0 {
+		//执行子命令
+		runCommand(flag.Arg(0), flag.Args()[1:])
+		return
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	//同步Spotify.exe的路径
 	go syncSpotifyAppPath(ctx)
-	// 启动协程
+	loadOauthConfig()
+	//按规则分发收件箱中的曲目
+	processInbox()
+	//唯一的路由 同时提供授权回调 页面和接口 生命周期贯穿整个程序
+	server, err := startServer(newRouter())
+	if err != nil {
+		fmt.Println("服务器启动失败: ", err)
+		os.Exit(1)
+	}
+	defer shutdownServer(server)
+	fmt.Println("Auth服务成功启动!")
+	// 启动业务处理
 	go boot()
-	// 认证协程
-	go callback(server)
-	// 等待两个协程执行完毕
-	wg.Wait()
+	// 等待业务处理完毕(直接使用已有token 或者经过/callback授权)
+	<-authDone
 	//数据处理完成
 	cancel()
-	//如果生成的uncategorized.json不是空的json串 则开启一个服务 去提供访问
+	//如果生成的uncategorized.json不是空的json串 则提供分类预览页面
 	uncategorizedFile, err := os.Open(filepath.Join(spotifyConfigBasePath, "uncategorized.json"))
 	if os.IsNotExist(err) {
+		//上次没能还原的本地文件来源
+		restoreLocalSources()
 		fmt.Println("处理完成! \n3秒后关闭此窗口...")
 		time.Sleep(3 * time.Second)
 		return
 	} else if err == nil {
 		uncategorizedData := make(map[string][]util.MP3MetaInfo)
-		//	对uncategorizedFile进行反序列化 如果是个空结果 说明没有待分类的曲目;如果不是空 取出结果 开启server 向用户提供端点 使用默认浏览器打开该URL
+		//	对uncategorizedFile进行反序列化 如果是个空结果 说明没有待分类的曲目;如果不是空 取出结果 向用户提供端点 使用默认浏览器打开该URL
 		decoder := json.NewDecoder(uncategorizedFile)
 		err := decoder.Decode(&uncategorizedData)
+		_ = uncategorizedFile.Close()
 		if err != nil {
 			fmt.Println("反序列化失败! ", err)
 			os.Exit(1)
 		}
-		if uncategorizedData == nil || len(uncategorizedData) == 0 {
+		if len(uncategorizedData) == 0 && !watchMode {
+			restoreLocalSources()
 			fmt.Println("处理完成! 没有待分类的曲目! \n3秒后关闭此窗口...")
 			time.Sleep(3 * time.Second)
 			return
 		}
-
-		engine := gin.Default()
-		//创建一个信号来监听终止事件  来将分好类的临时曲目移动到对应的spotify_local文件夹中  同时保留文件夹里未分类的临时曲目 序列化uncategorized.json的时候还要包含上一次处理后临时文件夹的未处理曲目
-		//os.Interrupt 是一个预定义的常量，表示中断信号，通常由用户按下 Ctrl+C 键触发。
-		//注册系统中断和终止信号
-		//syscall.SIGTERM 是一个系统调用信号，表示终止信号，通常由操作系统或其他进程发送给目标进程，要求其正常终止。
-		leftTracksChan := make(chan map[string][]util.MP3MetaInfo)
-
-		tempData := uncategorizedData
-
-		//// 将根路由指定为静态文件
-		//engine.GET("/", func(c *gin.Context) {
-		//	c.File("./static/index.html")
-		//})
-
-		// 路由到 jsonview.js
-		engine.GET("static/js/jsonview.js", func(c *gin.Context) {
-			content, err := jsFile.ReadFile("static/js/jsonview.js")
-			if err != nil {
-				c.String(http.StatusInternalServerError, "Internal Server Error")
-				return
-			}
-			c.Data(http.StatusOK, "application/javascript", content)
-		})
-
-		// 路由到 index.html
-		engine.GET("/", func(c *gin.Context) {
-			content, err := htmlFile.ReadFile("static/index.html")
-			if err != nil {
-				c.String(http.StatusInternalServerError, "Internal Server Error")
-				return
-			}
-			c.Data(http.StatusOK, "text/html; charset=utf-8", content)
-		})
-
-		//查询分类信息
-		engine.GET("/uncategorized", func(c *gin.Context) {
-			select {
-			case data := <-leftTracksChan:
-				tempData = data
-				c.JSON(200, tempData)
-			default:
-				//说明已获取完毕 直接返回最后一次的tempData
-				c.JSON(200, tempData)
-			}
-		})
-
-		go func() {
-			engine.Run(":" + strconv.Itoa(listenPort))
-		}()
+		//分类预览页面从这里开始可以查询到数据
+		setUncategorized(uncategorizedData)
 
 		//终止信号
 		exitSignal := make(chan struct{})
 		//需要移动的文件路径  值为映射表  该映射表的键为临时文件路径 值为原文件路径
 		tickedTracksFilesChan := make(chan []map[string]string, 1)
-		go getCategorizeStat(uncategorizedData, leftTracksChan, tickedTracksFilesChan, exitSignal)
+		go getCategorizeStat(uncategorizedData, tickedTracksFilesChan, exitSignal)
+		if watchMode {
+			watchCtx, stopWatching := context.WithCancel(context.Background())
+			defer stopWatching()
+			go watchLocalLibrary(watchCtx)
+			//Ctrl+C结束监听 已分类的曲目照常移回spotify_local
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt)
+			go func() {
+				<-signals
+				stopWatching()
+				close(stopWatch)
+			}()
+		}
 
-		fmt.Print("请打开spotify客户端 设置=>添加歌曲来源=>选择spotify_local_temp文件夹,取消勾选spotify_local文件夹\n\n")
-		openURL(fmt.Sprintf("http://127.0.0.1:%d", listenPort))
+		openURL(sessionURL())
+		setPageSessionActive(true)
+		//自动切换本地文件来源 未开启或失败时由用户手动切换
+		if !switchLocalSources() {
+			fmt.Print("请打开spotify客户端 设置=>添加歌曲来源=>选择spotify_local_temp文件夹,取消勾选spotify_local文件夹\n\n")
+		}
 
-		select {
 		//等待终止信号
-		case <-exitSignal:
-			//后置处理
-			postProcess(tickedTracksFilesChan)
-			break
-		}
+		<-exitSignal
+		//分类结束后页面不再轮询 之后的询问改在控制台
+		setPageSessionActive(false)
+		//后置处理
+		postProcess(tickedTracksFilesChan)
 	}
 }
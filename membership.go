@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 多歌单归属清单 存放在spotify_local中 随曲库一起同步
+// 键为文件相对spotify_local的路径(歌单文件夹/文件名) 值为除所在文件夹之外 同样收录了该文件的歌单
+const membershipFileName = "membership.json"
+
+// membershipKey 清单中的键
+func membershipKey(folder, fileName string) string {
+	return path.Join(folder, fileName)
+}
+
+// loadMembership 读取多歌单归属清单
+func loadMembership() map[string][]string {
+	membership := make(map[string][]string)
+	membershipFile, err := os.Open(filepath.Join(spotifyLocalPath, membershipFileName))
+	if err != nil {
+		return membership
+	}
+	defer membershipFile.Close()
+	if err := json.NewDecoder(membershipFile).Decode(&membership); err != nil {
+		fmt.Println("membership.json解析失败: ", err)
+	}
+	return membership
+}
+
+// saveMembership 保存多歌单归属清单
+func saveMembership(membership map[string][]string) {
+	membershipFile, err := os.Create(filepath.Join(spotifyLocalPath, membershipFileName))
+	if err != nil {
+		fmt.Println("无法写入membership.json: ", err)
+		return
+	}
+	defer membershipFile.Close()
+	encoder := json.NewEncoder(membershipFile)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(membership)
+}
+
+// addMemberships 记录文件同时属于其他歌单
+func addMemberships(memberships []trackMembership) {
+	membership := loadMembership()
+	for _, item := range memberships {
+		key := membershipKey(item.Folder, item.Track.FileName)
+		for _, playListName := range item.PlayLists {
+			if playListName != item.Folder && !containsString(membership[key], playListName) {
+				membership[key] = append(membership[key], playListName)
+			}
+		}
+		sort.Strings(membership[key])
+	}
+	saveMembership(membership)
+}
+
+// removeMembership 移除失效的归属
+func removeMembership(folder, fileName, playListName string) {
+	membership := loadMembership()
+	key := membershipKey(folder, fileName)
+	remaining := make([]string, 0)
+	for _, name := range membership[key] {
+		if name != playListName {
+			remaining = append(remaining, name)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(membership, key)
+	} else {
+		membership[key] = remaining
+	}
+	saveMembership(membership)
+}
+
+// moveMembership 文件换了歌单文件夹之后 更新清单中的键 新文件夹不再作为额外歌单出现
+func moveMembership(fromFolder, toFolder, fileName string) {
+	membership := loadMembership()
+	fromKey := membershipKey(fromFolder, fileName)
+	playLists, ok := membership[fromKey]
+	if !ok {
+		return
+	}
+	delete(membership, fromKey)
+	remaining := make([]string, 0)
+	for _, name := range playLists {
+		if name != toFolder {
+			remaining = append(remaining, name)
+		}
+	}
+	if len(remaining) != 0 {
+		membership[membershipKey(toFolder, fileName)] = remaining
+	}
+	saveMembership(membership)
+}
+
+// applyMembership 按清单把文件也加入到其他歌单的本地曲目中 这些曲目的LinkedFrom指向实际所在的文件夹
+func applyMembership(localMusicMetaData map[string][]util.MP3MetaInfo) {
+	for key, playLists := range loadMembership() {
+		folder, fileName := path.Split(key)
+		folder = path.Clean(folder)
+		var canonical *util.MP3MetaInfo
+		for i, track := range localMusicMetaData[folder] {
+			if track.FileName == fileName && track.LinkedFrom == "" {
+				canonical = &localMusicMetaData[folder][i]
+				break
+			}
+		}
+		if canonical == nil {
+			//文件不在原文件夹 移动方式暂存时在暂存区中 以暂存的文件为准 否则其他歌单会把它当作孤儿曲目
+			staged, ok := stagedMembershipTrack(folder, fileName)
+			if !ok {
+				//已被删除
+				continue
+			}
+			canonical = &staged
+		}
+		for _, playListName := range playLists {
+			tracks, ok := localMusicMetaData[playListName]
+			if !ok {
+				continue
+			}
+			linked := *canonical
+			linked.PlayListName = playListName
+			linked.LinkedFrom = folder
+			localMusicMetaData[playListName] = append(tracks, linked)
+		}
+	}
+}
+
+// stagedMembershipTrack 读取暂存区中歌单文件夹对应位置的文件
+func stagedMembershipTrack(folder, fileName string) (util.MP3MetaInfo, bool) {
+	stagedPath := filepath.Join(spotifyLocalTempPath, folder, fileName)
+	info, err := os.Stat(stagedPath)
+	if err != nil || info.IsDir() {
+		return util.MP3MetaInfo{}, false
+	}
+	meta, err := getLibraryIndex().extract(stagedPath, info)
+	meta, err = inferMissingTags(stagedPath, meta, err)
+	if err != nil {
+		return util.MP3MetaInfo{}, false
+	}
+	return meta, true
+}
+
+// containsString 判断未排序的切片中是否存在元素
+func containsString(slice []string, element string) bool {
+	for _, item := range slice {
+		if item == element {
+			return true
+		}
+	}
+	return false
+}
+
+// dropStaleMemberships 清单中记录了归属 但歌单已经不再收录的曲目 从清单中移除 文件本身保持不动
+func dropStaleMemberships(unHandledTracks []util.MP3MetaInfo) []util.MP3MetaInfo {
+	remaining := make([]util.MP3MetaInfo, 0, len(unHandledTracks))
+	for _, track := range unHandledTracks {
+		if track.LinkedFrom == "" {
+			remaining = append(remaining, track)
+			continue
+		}
+		fmt.Printf("歌单: %v 已不再收录 %v/%v, 从membership.json中移除\n", track.PlayListName, track.LinkedFrom, track.FileName)
+		removeMembership(track.LinkedFrom, track.FileName, track.PlayListName)
+	}
+	return remaining
+}
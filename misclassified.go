@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// trackMembership 本地文件与收录它的歌单
+type trackMembership struct {
+	//曲目元信息
+	Track util.MP3MetaInfo
+	//文件所在的歌单文件夹
+	Folder string
+	//收录了该曲目的歌单
+	PlayLists []string
+}
+
+// sortedKeys 返回排好序的歌单名称 保证输出稳定
+func sortedKeys(data map[string][]util.MP3MetaInfo) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// findMisclassified 跨歌单比对未分类的曲目
+// 文件在A文件夹 但只被歌单B收录 说明放错了文件夹; 被多个其他歌单收录的单独列出
+func findMisclassified(unHandledData map[string][]util.MP3MetaInfo, onlineTracks map[string][]util.MP3MetaInfo) (misclassified []trackMembership, shared []trackMembership) {
+	misclassified = make([]trackMembership, 0)
+	shared = make([]trackMembership, 0)
+	playListNames := sortedKeys(onlineTracks)
+	for _, folder := range sortedKeys(unHandledData) {
+		for _, track := range unHandledData[folder] {
+			membership := trackMembership{Track: track, Folder: folder, PlayLists: make([]string, 0)}
+			for _, playListName := range playListNames {
+				if playListName == folder {
+					continue
+				}
+				if flag, _ := isTrackInLocalTracks(track, onlineTracks[playListName]); flag {
+					membership.PlayLists = append(membership.PlayLists, playListName)
+				}
+			}
+			if len(membership.PlayLists) == 1 {
+				misclassified = append(misclassified, membership)
+			} else if len(membership.PlayLists) > 1 {
+				shared = append(shared, membership)
+			}
+		}
+	}
+	return
+}
+
+// resolveOrphans 在其他歌单文件夹中查找孤儿曲目对应的文件
+// 找到且该文件已被所在文件夹的同名歌单收录 说明它同时属于多个歌单; 未被收录的已经作为分类错误的曲目上报 两种情况都不再算作孤儿
+func resolveOrphans(orphans map[string][]playListLocalItem, localMusicMetaData map[string][]util.MP3MetaInfo, unHandledData map[string][]util.MP3MetaInfo) (shared []trackMembership) {
+	shared = make([]trackMembership, 0)
+	folders := sortedKeys(localMusicMetaData)
+	for playListName, items := range orphans {
+		remaining := make([]playListLocalItem, 0, len(items))
+		for _, item := range items {
+			found := false
+			for _, folder := range folders {
+				if folder == playListName {
+					continue
+				}
+				flag, filename := isTrackInLocalTracks(item.Track, physicalTracks(localMusicMetaData[folder]))
+				if !flag {
+					continue
+				}
+				found = true
+				if unHandled, _ := isTrackInLocalTracks(item.Track, unHandledData[folder]); !unHandled {
+					track := item.Track
+					track.FileName = filename
+					shared = append(shared, trackMembership{Track: track, Folder: folder, PlayLists: []string{folder, playListName}})
+				}
+				break
+			}
+			if !found {
+				remaining = append(remaining, item)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(orphans, playListName)
+		} else {
+			orphans[playListName] = remaining
+		}
+	}
+	return
+}
+
+// reportMisclassified 打印分类错误和属于多个歌单的曲目 并写入misclassified.json
+func reportMisclassified(misclassified []trackMembership, shared []trackMembership) {
+	for _, membership := range misclassified {
+		fmt.Printf("分类错误: %v/%v 应放在歌单文件夹: %v\n", membership.Folder, membership.Track.FileName, membership.PlayLists[0])
+	}
+	for _, membership := range shared {
+		fmt.Printf("属于多个歌单: %v/%v 被这些歌单收录: %v\n", membership.Folder, membership.Track.FileName, membership.PlayLists)
+	}
+	misclassifiedFile, err := os.Create(filepath.Join(spotifyConfigBasePath, "misclassified.json"))
+	if err != nil {
+		fmt.Println("无法创建misclassified.json: ", err)
+		return
+	}
+	defer misclassifiedFile.Close()
+	encoder := json.NewEncoder(misclassifiedFile)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(map[string][]trackMembership{
+		"misclassified": misclassified,
+		"shared":        shared,
+	})
+}
+
+// moveMisclassified 将分类错误的曲目移动到收录它的歌单文件夹 返回移动成功的曲目
+func moveMisclassified(misclassified []trackMembership) []trackMembership {
+	moved := make([]trackMembership, 0, len(misclassified))
+	for _, membership := range misclassified {
+		source := filepath.Join(spotifyLocalPath, membership.Folder, membership.Track.FileName)
+		dest := filepath.Join(spotifyLocalPath, membership.PlayLists[0], membership.Track.FileName)
+		finalPath, err := moveFile(source, dest)
+		if err != nil {
+			fmt.Println("文件移动失败: ", err)
+			continue
+		}
+		moveMembership(membership.Folder, membership.PlayLists[0], filepath.Base(finalPath))
+		moved = append(moved, membership)
+	}
+	return moved
+}
+
+// physicalTracks 过滤掉多歌单归属的曲目 只保留文件确实在该文件夹中的曲目
+func physicalTracks(tracks []util.MP3MetaInfo) []util.MP3MetaInfo {
+	res := make([]util.MP3MetaInfo, 0, len(tracks))
+	for _, track := range tracks {
+		if track.LinkedFrom == "" {
+			res = append(res, track)
+		}
+	}
+	return res
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 目标文件已存在时的处理策略
+const (
+	//跳过 源文件留在原处
+	conflictSkip = "skip"
+	//两个都保留 移动过去的文件名加上(1)(2)...后缀
+	conflictSuffix = "suffix"
+	//内容完全相同时用源文件替换目标文件 不同时跳过
+	conflictReplaceIdentical = "replace-identical"
+	//在分类预览页面上由用户决定 页面未打开或分类已结束时在控制台询问
+	conflictAsk = "ask"
+)
+
+// 冲突的处理结果
+const (
+	resolutionSkipped  = "skipped"
+	resolutionSuffixed = "suffixed"
+	resolutionReplaced = "replaced"
+	resolutionPending  = "pending"
+)
+
+var (
+	//目标文件已存在 源文件没有移动
+	errMoveConflict = errors.New("目标文件已存在")
+	//所有冲突记录
+	moveConflicts = make([]*moveConflict, 0)
+	//moveConflicts的锁
+	moveConflictsMutex sync.Mutex
+)
+
+// moveConflict 移动文件时目标文件已存在
+type moveConflict struct {
+	//编号 网页上处理冲突时使用
+	ID int
+	//发生时间
+	Time string
+	//源文件
+	Source string
+	//目标文件
+	Dest string
+	//两个文件的内容是否完全相同
+	Identical bool
+	//使用的策略
+	Policy string
+	//处理结果 skipped/suffixed/replaced/pending
+	Resolution string
+	//源文件最终的位置
+	FinalPath string `json:",omitempty"`
+	//被替换的目标文件移到的隔离位置
+	ReplacedTo string `json:",omitempty"`
+}
+
+// conflictPolicy 配置的冲突策略 无效时使用suffix
+func conflictPolicy() string {
+	switch appConf.ConflictPolicy {
+	case conflictSkip, conflictSuffix, conflictReplaceIdentical, conflictAsk:
+		return appConf.ConflictPolicy
+	default:
+		return conflictSuffix
+	}
+}
+
+// moveFile 移动文件 目标文件已存在时按配置的策略处理 返回文件最终的路径
+// 冲突导致源文件没有移动时返回errMoveConflict
+func moveFile(source string, dest string) (string, error) {
+	return moveFileWith(source, dest, conflictPolicy())
+}
+
+// moveFileWith 按指定的冲突策略移动文件 所有冲突都会被记录和报告 不会覆盖内容不同的文件
+func moveFileWith(source string, dest string, policy string) (string, error) {
+	if _, err := os.Lstat(source); err != nil {
+		return "", err
+	}
+	destInfo, err := os.Lstat(dest)
+	if os.IsNotExist(err) {
+		return dest, renameFile(source, dest)
+	} else if err != nil {
+		return "", err
+	}
+	if sourceInfo, err := os.Stat(source); err == nil && os.SameFile(sourceInfo, destInfo) {
+		//同一个文件(如不区分大小写的文件系统上只改了大小写)
+		return dest, renameFile(source, dest)
+	}
+
+	conflict := &moveConflict{
+		Time:      time.Now().Format(time.DateTime),
+		Source:    source,
+		Dest:      dest,
+		Identical: isIdenticalFile(source, dest),
+		Policy:    policy,
+	}
+	finalPath, err := resolveConflict(conflict, policy)
+	recordConflict(conflict)
+	return finalPath, err
+}
+
+// resolveConflict 按策略处理冲突 结果写入conflict
+func resolveConflict(conflict *moveConflict, policy string) (string, error) {
+	switch policy {
+	case conflictSuffix:
+		finalPath := uniquePath(conflict.Dest)
+		if err := renameFile(conflict.Source, finalPath); err != nil {
+			return "", err
+		}
+		conflict.Resolution = resolutionSuffixed
+		conflict.FinalPath = finalPath
+		return finalPath, nil
+	case conflictReplaceIdentical:
+		if !conflict.Identical {
+			conflict.Resolution = resolutionSkipped
+			return "", errMoveConflict
+		}
+		//内容完全相同 替换不会丢失数据
+		if err := renameFile(conflict.Source, conflict.Dest); err != nil {
+			return "", err
+		}
+		conflict.Resolution = resolutionReplaced
+		conflict.FinalPath = conflict.Dest
+		return conflict.Dest, nil
+	case conflictAsk:
+		if !isPageSessionActive() {
+			return askConflictInConsole(conflict)
+		}
+		//先跳过 等待网页上的决定
+		conflict.Resolution = resolutionPending
+		return "", errMoveConflict
+	default:
+		conflict.Resolution = resolutionSkipped
+		return "", errMoveConflict
+	}
+}
+
+// askConflictInConsole 在控制台询问如何处理冲突 直接回车视为跳过
+func askConflictInConsole(conflict *moveConflict) (string, error) {
+	same := "内容不同"
+	if conflict.Identical {
+		same = "内容相同"
+	}
+	fmt.Printf("目标文件已存在(%v): %v => %v\n跳过(s) 两个都保留(k) 替换(r)? (S/k/r): ", same, conflict.Source, conflict.Dest)
+	answer, _ := stdinReader.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "k":
+		return resolveConflict(conflict, conflictSuffix)
+	case "r":
+		if err := replaceConflict(conflict); err != nil {
+			return "", err
+		}
+		return conflict.Dest, nil
+	default:
+		conflict.Resolution = resolutionSkipped
+		return "", errMoveConflict
+	}
+}
+
+// resolvePendingConflicts 分类结束后页面上没来得及处理的冲突改在控制台询问
+func resolvePendingConflicts() {
+	moveConflictsMutex.Lock()
+	defer moveConflictsMutex.Unlock()
+	for _, conflict := range moveConflicts {
+		if conflict.Resolution != resolutionPending {
+			continue
+		}
+		if _, err := askConflictInConsole(conflict); err != nil && !errors.Is(err, errMoveConflict) {
+			fmt.Println("处理冲突失败: ", err)
+		}
+	}
+	saveConflicts()
+}
+
+// 文件被占用时的重试间隔 依次加倍
+var lockRetryDelays = []time.Duration{
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2 * time.Second,
+	4 * time.Second,
+}
+
+// renameFile 所有移动文件的底层实现 目标文件存在时会被覆盖 调用方负责处理冲突
+// 跨磁盘时改为复制+校验+删除 文件暂时被占用时等待后重试
+func renameFile(source string, dest string) error {
+	return retryOnLock(func() error {
+		err := os.Rename(source, dest)
+		if err != nil && isCrossDeviceError(err) {
+			return copyAndRemove(source, dest)
+		}
+		return err
+	})
+}
+
+// retryOnLock 文件暂时被占用时按lockRetryDelays等待后重试
+// 按SpotifyClosePolicy的时机关闭spotify(可能是它占用了文件) 关闭后立即再试
+func retryOnLock(operation func() error) error {
+	err := operation()
+	askedClose := false
+	for retries := 0; err != nil && isTransientLockError(err); retries++ {
+		if !askedClose && wantCloseSpotify(retries) {
+			askedClose = true
+			if closeSpotifyForLock(err) {
+				err = operation()
+				continue
+			}
+		}
+		if retries >= len(lockRetryDelays) {
+			break
+		}
+		time.Sleep(lockRetryDelays[retries])
+		err = operation()
+	}
+	return err
+}
+
+// copyAndRemove 跨磁盘移动: 复制并校验后删除源文件
+// 任何一步失败都保留源文件 不会丢失数据
+func copyAndRemove(source string, dest string) error {
+	if err := copyFileVerified(source, dest); err != nil {
+		return err
+	}
+	if err := os.Remove(source); err != nil {
+		//源文件删不掉时撤销复制 保持只有一份
+		_ = os.Remove(dest)
+		return err
+	}
+	return nil
+}
+
+// copyFileVerified 复制到目标文件夹的临时文件 落盘并校验后改名为目标文件 保留修改时间
+// 失败时不会留下不完整的目标文件
+func copyFileVerified(source string, dest string) error {
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	sourceHash, err := util.HashFile(source)
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*.partial")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	copyErr := func() error {
+		sourceFile, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer sourceFile.Close()
+		if _, err := io.Copy(tempFile, sourceFile); err != nil {
+			return err
+		}
+		return tempFile.Sync()
+	}()
+	if err := tempFile.Close(); copyErr == nil {
+		copyErr = err
+	}
+	if copyErr == nil {
+		//校验复制结果 云盘和网络磁盘上可能写入不完整
+		if tempHash, err := util.HashFile(tempPath); err != nil {
+			copyErr = err
+		} else if tempHash != sourceHash {
+			copyErr = fmt.Errorf("复制后校验失败: %v", source)
+		}
+	}
+	if copyErr == nil {
+		_ = os.Chmod(tempPath, sourceInfo.Mode())
+		//保留修改时间 曲库索引和spotify都依赖它
+		copyErr = os.Chtimes(tempPath, sourceInfo.ModTime(), sourceInfo.ModTime())
+	}
+	if copyErr == nil {
+		copyErr = os.Rename(tempPath, dest)
+	}
+	if copyErr != nil {
+		_ = os.Remove(tempPath)
+	}
+	return copyErr
+}
+
+// isIdenticalFile 两个文件的内容是否完全相同
+func isIdenticalFile(path1 string, path2 string) bool {
+	info1, err1 := os.Stat(path1)
+	info2, err2 := os.Stat(path2)
+	if err1 != nil || err2 != nil || info1.Size() != info2.Size() {
+		return false
+	}
+	hash1, err1 := util.HashFile(path1)
+	hash2, err2 := util.HashFile(path2)
+	return err1 == nil && err2 == nil && hash1 == hash2
+}
+
+// uniquePath 在文件名后加上(1)(2)...直到不与已有文件重名
+func uniquePath(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// recordConflict 记录冲突 打印并写入conflicts.json
+func recordConflict(conflict *moveConflict) {
+	moveConflictsMutex.Lock()
+	defer moveConflictsMutex.Unlock()
+	conflict.ID = len(moveConflicts) + 1
+	moveConflicts = append(moveConflicts, conflict)
+	fmt.Printf("目标文件已存在(%v): %v => %v\n", conflict.Resolution, conflict.Source, conflict.Dest)
+	saveConflicts()
+}
+
+// saveConflicts 写入conflicts.json 调用方需持有moveConflictsMutex
+func saveConflicts() {
+	conflictsFile, err := os.Create(filepath.Join(spotifyConfigBasePath, "conflicts.json"))
+	if err != nil {
+		fmt.Println("无法创建conflicts.json: ", err)
+		return
+	}
+	defer conflictsFile.Close()
+	encoder := json.NewEncoder(conflictsFile)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(moveConflicts)
+}
+
+// reportConflicts 汇总本次运行中的冲突
+func reportConflicts() {
+	moveConflictsMutex.Lock()
+	defer moveConflictsMutex.Unlock()
+	if len(moveConflicts) == 0 {
+		return
+	}
+	counts := make(map[string]int)
+	for _, conflict := range moveConflicts {
+		counts[conflict.Resolution]++
+	}
+	fmt.Printf("本次共有%d个文件冲突: %v, 详见conflicts.json\n", len(moveConflicts), counts)
+}
+
+// serveConflicts 查询所有冲突
+func serveConflicts(c *gin.Context) {
+	moveConflictsMutex.Lock()
+	defer moveConflictsMutex.Unlock()
+	c.JSON(http.StatusOK, moveConflicts)
+}
+
+// conflictAction 网页上对冲突的处理
+type conflictAction struct {
+	//skip: 跳过 suffix: 两个都保留 replace: 替换目标文件(内容不同时原目标文件移入隔离文件夹)
+	Action string
+}
+
+// handleConflict 处理网页上待决定的冲突
+func handleConflict(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	var action conflictAction
+	if err == nil {
+		err = c.ShouldBindJSON(&action)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "参数无效"})
+		return
+	}
+	moveConflictsMutex.Lock()
+	defer moveConflictsMutex.Unlock()
+	if id < 1 || id > len(moveConflicts) || moveConflicts[id-1].Resolution != resolutionPending {
+		c.JSON(http.StatusNotFound, gin.H{"message": "没有待处理的冲突"})
+		return
+	}
+	conflict := moveConflicts[id-1]
+	switch action.Action {
+	case "skip":
+		conflict.Resolution = resolutionSkipped
+	case "suffix":
+		_, err = resolveConflict(conflict, conflictSuffix)
+	case "replace":
+		err = replaceConflict(conflict)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"message": "未知的操作: " + action.Action})
+		return
+	}
+	saveConflicts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, conflict)
+}
+
+// replaceConflict 用源文件替换目标文件 内容不同时原目标文件先移入隔离文件夹 不会丢失数据
+func replaceConflict(conflict *moveConflict) error {
+	if !isIdenticalFile(conflict.Source, conflict.Dest) {
+		replacedTo, err := quarantineFile(conflict.Dest, "replaced")
+		if err != nil {
+			return err
+		}
+		conflict.ReplacedTo = replacedTo
+	}
+	if err := renameFile(conflict.Source, conflict.Dest); err != nil {
+		return err
+	}
+	conflict.Resolution = resolutionReplaced
+	conflict.FinalPath = conflict.Dest
+	return nil
+}
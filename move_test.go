@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeTestFile 写入测试文件并设置修改时间
+func writeTestFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// partialFiles 文件夹中残留的临时文件
+func partialFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make([]string, 0)
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".partial") {
+			res = append(res, entry.Name())
+		}
+	}
+	return res
+}
+
+func TestCopyAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.mp3")
+	dest := filepath.Join(dir, "dest.mp3")
+	data := bytes.Repeat([]byte("晴天"), 100*1024)
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	writeTestFile(t, source, data, modTime)
+
+	if err := copyAndRemove(source, dest); err != nil {
+		t.Fatalf("copyAndRemove: %v", err)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("source still exists: %v", err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("dest content differs: %v", err)
+	}
+	if info, err := os.Stat(dest); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("dest mtime = %v, %v, want %v", info.ModTime(), err, modTime)
+	}
+	if partials := partialFiles(t, dir); len(partials) != 0 {
+		t.Errorf("partial files left: %v", partials)
+	}
+}
+
+func TestCopyAndRemoveFailure(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.mp3")
+	writeTestFile(t, source, []byte("晴天"), time.Now())
+	//目标位置是非空文件夹 临时文件写入并校验后改名失败
+	dest := filepath.Join(dir, "dest.mp3")
+	if err := os.MkdirAll(filepath.Join(dest, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyAndRemove(source, dest); err == nil {
+		t.Fatal("copyAndRemove succeeded, want error")
+	}
+	if got, err := os.ReadFile(source); err != nil || string(got) != "晴天" {
+		t.Errorf("source = %q, %v, want kept", got, err)
+	}
+	if info, err := os.Stat(dest); err != nil || !info.IsDir() {
+		t.Errorf("dest was changed: %v", err)
+	}
+	if partials := partialFiles(t, dir); len(partials) != 0 {
+		t.Errorf("partial files left: %v", partials)
+	}
+}
+
+func TestCopyFileVerifiedMissingSource(t *testing.T) {
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "dest.mp3")
+	if err := copyFileVerified(filepath.Join(dir, "missing.mp3"), dest); err == nil {
+		t.Fatal("copyFileVerified succeeded, want error")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("dest exists: %v", err)
+	}
+}
+
+func TestRenameFile(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.mp3")
+	dest := filepath.Join(dir, "dest.mp3")
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local)
+	writeTestFile(t, source, []byte("晴天"), modTime)
+	writeTestFile(t, dest, []byte("七里香"), time.Now())
+
+	//renameFile不处理冲突 目标文件被覆盖
+	if err := renameFile(source, dest); err != nil {
+		t.Fatalf("renameFile: %v", err)
+	}
+	if got, err := os.ReadFile(dest); err != nil || string(got) != "晴天" {
+		t.Errorf("dest = %q, %v", got, err)
+	}
+	if info, err := os.Stat(dest); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("dest mtime = %v, %v, want %v", info.ModTime(), err, modTime)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("source still exists: %v", err)
+	}
+}
+
+func TestRetryOnLock(t *testing.T) {
+	if !isTransientLockError(syscall.EBUSY) {
+		t.Skip("EBUSY不是这个平台上的占用错误")
+	}
+	appConf = &appConfig{}
+
+	calls := 0
+	err := retryOnLock(func() error {
+		calls++
+		if calls == 1 {
+			return syscall.EBUSY
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("retryOnLock = %v after %d calls, want nil after 2", err, calls)
+	}
+
+	//其他错误不重试
+	calls = 0
+	errDenied := errors.New("denied")
+	err = retryOnLock(func() error {
+		calls++
+		return errDenied
+	})
+	if err != errDenied || calls != 1 {
+		t.Errorf("retryOnLock = %v after %d calls, want denied after 1", err, calls)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// windows错误码
+const (
+	errorNotSameDevice    = syscall.Errno(17)
+	errorSharingViolation = syscall.Errno(32)
+	errorLockViolation    = syscall.Errno(33)
+)
+
+// isCrossDeviceError 源和目标不在同一个磁盘上 不能直接重命名
+func isCrossDeviceError(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
+
+// isTransientLockError 文件暂时被占用(spotify正在播放 云盘客户端正在同步 杀毒软件正在扫描)
+// 拒绝访问通常是权限或只读属性导致的 重试没有意义
+func isTransientLockError(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/fs"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -32,13 +32,19 @@ func generateAuthorizationURL() (authorizationURL string) {
 		spotifyauth.WithClientID(spotifyClientID),
 		spotifyauth.WithClientSecret(spotifyClientSecret),
 		spotifyauth.WithScopes(scopes...))
-	authorizationURL = auth.AuthURL(state)
+	//每次登录尝试使用新的state
+	authorizationURL = auth.AuthURL(newAuthState())
 	return
 }
 
 // 通过code交换token
 func exchangeCodeForToken(w gin.ResponseWriter, r *http.Request) *oauth2.Token {
-	token, err := auth.Token(r.Context(), state, r)
+	actualState := r.FormValue("state")
+	if !consumeAuthState(actualState) {
+		http.Error(w, "State mismatch", http.StatusForbidden)
+		return nil
+	}
+	token, err := auth.Token(r.Context(), actualState, r)
 	if err != nil {
 		http.Error(w, "Could't get Token", http.StatusInternalServerError)
 		return nil
@@ -64,63 +70,30 @@ func getClient(r *http.Request, token *oauth2.Token) *http.Client {
 
 //获取本地文件夹`spotifyLocalPath`的歌单元信息
 
-// 数据结构:  key: 歌单名称 string   value:  歌曲名称切片 []util.MP3
-func getLocalMusicMetaData() map[string][]util.MP3MetaInfo {
-	//初始化一个映射
-	res := make(map[string][]util.MP3MetaInfo)
+// 数据结构:  key: 歌单名称 string   value:  歌曲名称切片 []util.MP3   第二个返回值为无法解析的文件
+func getLocalMusicMetaData() (map[string][]util.MP3MetaInfo, []scanError) {
 	//读取spotifyLocalPath
-	filepath.Walk(spotifyLocalPath, func(path string, info fs.FileInfo, err error) error {
-		if info != nil && info.IsDir() && info.Name() != "spotify_local" {
-			res[info.Name()] = make([]util.MP3MetaInfo, 0)
-		} else if strings.HasSuffix(info.Name(), ".mp3") {
-			mp3, err := util.ExtractMp3FromPath(path)
-			if err != nil {
-				//当前mp3无法处理 直接跳过
-				return nil
-			}
-			tracks, ok := res[mp3.PlayListName]
-			if ok {
-				//如果存在key
-				tracks := append(tracks, mp3)
-				res[mp3.PlayListName] = tracks
-			}
-		}
-		return handleError(err)
-	})
-
-	return res
+	result := scanLibrary(spotifyLocalPath)
+	getLibraryIndex().save()
+	res := result.groupByPlayList()
+	//按多歌单归属清单 把文件也计入其他歌单
+	applyMembership(res)
+	return res, result.Errors
 }
 
-// 加载临时文件夹到序列化数据中
-func loadLocalTempMusic() map[string][]util.MP3MetaInfo {
-	//初始化一个映射
-	res := make(map[string][]util.MP3MetaInfo)
-	//读取spotifyLocalPath
-	filepath.Walk(spotifyLocalTempPath, func(path string, info fs.FileInfo, err error) error {
-		if info != nil && info.IsDir() && info.Name() != "spotify_local_temp" {
-			res[info.Name()] = make([]util.MP3MetaInfo, 0)
-		} else if strings.HasSuffix(info.Name(), ".mp3") {
-			mp3, err := util.ExtractMp3FromPath(path)
-			if err != nil {
-				//当前mp3无法处理 直接跳过
-				return nil
-			}
-			tracks, ok := res[mp3.PlayListName]
-			if ok {
-				//如果存在key
-				tracks := append(tracks, mp3)
-				res[mp3.PlayListName] = tracks
-			}
-		}
-		return handleError(err)
-	})
+// 加载临时文件夹到序列化数据中 第二个返回值为无法解析的文件
+func loadLocalTempMusic() (map[string][]util.MP3MetaInfo, []scanError) {
+	//读取spotifyLocalTempPath
+	result := scanLibrary(spotifyLocalTempPath)
+	getLibraryIndex().save()
+	res := result.groupByPlayList()
 	// 移除切片长度为0的键值对
 	for key, value := range res {
 		if len(value) == 0 {
 			delete(res, key)
 		}
 	}
-	return res
+	return res, result.Errors
 }
 
 // getAllPlayLists 获取所有的歌单
@@ -177,77 +150,88 @@ func getAllPlayListsIds(sp *spotify.Client, ctx context.Context, userId string)
 	return res
 }
 
-// getTracksByPlayList 根据歌单 获取歌单所有的本地曲目
-func getTracksByPlayList(sp *spotify.Client, ctx context.Context, playList spotify.SimplePlaylist) ([]util.MP3MetaInfo, error) {
+// playListLocalItem 歌单中的本地曲目 以及它在歌单中的位置
+type playListLocalItem struct {
+	//曲目元信息
+	Track util.MP3MetaInfo
+	//spotify:local:开头的URI
+	URI spotify.URI
+	//在歌单中的位置(从0开始)
+	Position int
+}
+
+// getLocalItemsByPlayList 根据歌单 获取歌单所有的本地曲目及其位置
+func getLocalItemsByPlayList(sp *spotify.Client, ctx context.Context, playList spotify.SimplePlaylist) ([]playListLocalItem, error) {
 	pageItems, err := sp.GetPlaylistItems(ctx, playList.ID, spotify.Limit(100))
 	if err != nil {
 		fmt.Println("err: ", err)
-		return make([]util.MP3MetaInfo, 0), err
-	} else if pageItems.Total == 0 {
-		return make([]util.MP3MetaInfo, 0), nil
+		return make([]playListLocalItem, 0), err
 	}
-	limit := pageItems.Limit
-	offset := limit
-	total := pageItems.Total
-	items := pageItems.Items
 	//创建一个装载本地曲目的切片
-	localTracks := make([]util.MP3MetaInfo, 0)
-	//初始化装载
-	for _, item := range items {
-		if item.IsLocal {
-			trackName := item.Track.Track.Name
-			artists := item.Track.Track.Artists
-			if len(artists) == 0 || artists[0].Name == "" {
+	localItems := make([]playListLocalItem, 0)
+	offset := 0
+	for {
+		for i, item := range pageItems.Items {
+			if !item.IsLocal || item.Track.Track == nil {
 				continue
 			}
-			trackArtist := artists[0].Name
-			trackAlbum := item.Track.Track.Album.Name
-			localTracks = append(localTracks, util.MP3MetaInfo{
-				Title:        trackName,
-				Artist:       trackArtist,
-				Album:        trackAlbum,
+			track := util.MP3MetaInfo{
+				Title:        item.Track.Track.Name,
+				Album:        item.Track.Track.Album.Name,
 				PlayListName: playList.Name,
+				Duration:     item.Track.Track.Duration,
+				URI:          string(item.Track.Track.URI),
+			}
+			if artists := item.Track.Track.Artists; len(artists) != 0 {
+				track.Artist = artists[0].Name
+			}
+			localItems = append(localItems, playListLocalItem{
+				//没有艺术家的曲目按文件名模板推断
+				Track:    inferRemoteTrack(track),
+				URI:      item.Track.Track.URI,
+				Position: offset + i,
 			})
 		}
-	}
-	//更新items
-	for offset < total {
-		playlistItems, err := sp.GetPlaylistItems(ctx, playList.ID, spotify.Limit(limit), spotify.Offset(offset))
-		if err != nil || playlistItems.Total == 0 {
+		//更新offset
+		offset += len(pageItems.Items)
+		if len(pageItems.Items) == 0 || offset >= pageItems.Total {
 			break
 		}
-		for _, item := range playlistItems.Items {
-			if item.IsLocal {
-				trackName := item.Track.Track.Name
-				artists := item.Track.Track.Artists
-				if len(artists) == 0 || artists[0].Name == "" {
-					continue
-				}
-				trackArtist := artists[0].Name
-				trackAlbum := item.Track.Track.Album.Name
-				localTracks = append(localTracks, util.MP3MetaInfo{
-					Title:        trackName,
-					Artist:       trackArtist,
-					Album:        trackAlbum,
-					PlayListName: playList.Name,
-				})
-			}
+		pageItems, err = sp.GetPlaylistItems(ctx, playList.ID, spotify.Limit(100), spotify.Offset(offset))
+		if err != nil {
+			//只查到一部分时返回错误 避免把没查到的曲目当作未收录
+			return localItems, err
 		}
-		//更新offset
-		offset += limit
 	}
-	return localTracks, nil
+	return localItems, nil
+}
+
+// getTracksByPlayList 根据歌单 获取歌单所有的本地曲目
+func getTracksByPlayList(sp *spotify.Client, ctx context.Context, playList spotify.SimplePlaylist) ([]util.MP3MetaInfo, error) {
+	localItems, err := getLocalItemsByPlayList(sp, ctx, playList)
+	localTracks := make([]util.MP3MetaInfo, 0, len(localItems))
+	for _, item := range localItems {
+		localTracks = append(localTracks, item.Track)
+	}
+	return localTracks, err
 }
 
 // isTrackInLocalTracks 判断spotify已收录元信息的曲目是否存在于本地库
 func isTrackInLocalTracks(track util.MP3MetaInfo, localTracks []util.MP3MetaInfo) (flag bool, filename string) {
 
 	//	hahaha
+	//URI中是spotify从文件标签读出的原始字符串 优先精确匹配
+	if identity, err := util.ParseLocalURI(track.URI); err == nil {
+		for _, localTrack := range localTracks {
+			if identity.Matches(localTrack) && isSimilarDuration(localTrack.Duration, identity.Seconds*1000) {
+				return true, localTrack.FileName
+			}
+		}
+	}
+	//精确匹配不到时按相似度匹配
 loop:
 	for _, localTrack := range localTracks {
-		if util.EvaluateSimilar(localTrack.Artist, track.Artist) &&
-			util.EvaluateSimilar(localTrack.Title, track.Title) &&
-			util.EvaluateSimilar(localTrack.Album, track.Album) {
+		if isSameTrack(localTrack, track) {
 			if localTrack.FileName != "" {
 				filename = localTrack.FileName
 			} else if track.FileName != "" {
@@ -260,13 +244,35 @@ loop:
 	return
 }
 
-// removeTrack 移除曲目
+// isSameTrack 判断两个曲目是否为同一首 艺术家 标题 专辑相似 且时长在误差范围内
+// 任意一边时长未知时不比较时长
+func isSameTrack(track1 util.MP3MetaInfo, track2 util.MP3MetaInfo) bool {
+	return util.EvaluateSimilar(track1.Artist, track2.Artist) &&
+		util.EvaluateSimilar(track1.Title, track2.Title) &&
+		util.EvaluateSimilar(track1.Album, track2.Album) &&
+		isSimilarDuration(track1.Duration, track2.Duration)
+}
+
+// isSimilarDuration 判断两个时长(毫秒)是否在配置的误差范围内
+func isSimilarDuration(duration1 int, duration2 int) bool {
+	if duration1 == 0 || duration2 == 0 || appConf.DurationTolerance <= 0 {
+		return true
+	}
+	diff := duration1 - duration2
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= appConf.DurationTolerance*1000
+}
+
+// removeTrack 移除曲目 知道文件名时按文件名移除 否则移除所有相似的曲目
 func removeTrack(localTracks []util.MP3MetaInfo, track util.MP3MetaInfo) []util.MP3MetaInfo {
 	newTracks := make([]util.MP3MetaInfo, 0)
 	for _, localTrack := range localTracks {
-		if util.EvaluateSimilar(localTrack.Artist, track.Artist) &&
-			util.EvaluateSimilar(localTrack.Title, track.Title) &&
-			util.EvaluateSimilar(localTrack.Album, track.Album) {
+		if track.FileName != "" && localTrack.FileName == track.FileName {
+			continue
+		}
+		if track.FileName == "" && isSameTrack(localTrack, track) {
 			continue
 		}
 		newTracks = append(newTracks, localTrack)
@@ -292,97 +298,65 @@ func diffTracks(localTracks []util.MP3MetaInfo, tracks []util.MP3MetaInfo) ([]ut
 	return localTracks, tickedTracks
 }
 
-func moveToTemp(unHandledTracks []util.MP3MetaInfo, playListName string) {
+// moveToTemp 把未分类的曲目放入暂存区 返回本次实际暂存的曲目 FileName为暂存区中的文件名
+// 已在暂存区 暂存失败或因冲突没有移动的曲目不返回
+func moveToTemp(unHandledTracks []util.MP3MetaInfo, playListName string) []util.MP3MetaInfo {
+	stagedTracks := make([]util.MP3MetaInfo, 0, len(unHandledTracks))
 	basePath := filepath.Join(spotifyLocalPath, playListName)
 	tempBasePath := filepath.Join(spotifyLocalTempPath, playListName)
-	mp3Files := make([]util.MP3MetaInfo, 0)
-	err := filepath.Walk(tempBasePath, func(path string, info fs.FileInfo, err error) error {
-		//如果当前文件是mp3
-		if err != nil && info != nil && !info.IsDir() && strings.HasSuffix(info.Name(), ".mp3") {
-			metaInfo, err := util.ExtractMp3FromPath(path)
-			if err != nil {
-				//当前mp3处理失败下一个
-				return nil
-			}
-			mp3Files = append(mp3Files, metaInfo)
-		}
-		return err
-	})
+	//	路径不存在 创建目录
+	err := os.MkdirAll(tempBasePath, os.ModeDir)
 	if err != nil {
-		//	路径不存在 创建目录
-		err = os.Mkdir(tempBasePath, os.ModeDir)
-		if err != nil {
-			fmt.Println("创建目录失败")
-			return
-		}
-		moveToTemp(unHandledTracks, playListName)
-		return
+		fmt.Println("创建目录失败")
+		return stagedTracks
 	}
+	//临时文件夹中已有的曲目
+	mp3Files := scanLibrary(tempBasePath).Tracks
 	//	遍历unHandledTracks 如果存在和mp3Files中匹配的mp3文件就跳过
 	for _, track := range unHandledTracks {
+		if track.LinkedFrom != "" {
+			//多歌单归属的曲目文件在其他文件夹 不移动
+			continue
+		}
 		if flag, _ := isTrackInLocalTracks(track, mp3Files); flag {
 			continue
 		}
-		source := filepath.Join(basePath, track.FileName)
-		dest := filepath.Join(tempBasePath, track.FileName)
-		//移动到对应的临时文件夹
-		err := os.Rename(source, dest)
+		//放入对应的临时文件夹 按配置移动或者创建链接
+		stagedPath, err := stageFile(filepath.Join(basePath, track.FileName), filepath.Join(tempBasePath, track.FileName))
 		if err != nil {
-			_ = closeSpotifyProcess()
-			err = os.Rename(source, dest)
-			if err != nil {
-				fmt.Println("文件移动失败: ", err)
-				continue
-			}
+			fmt.Println("文件暂存失败: ", err)
+			continue
 		}
+		if stagedPath == "" {
+			continue
+		}
+		track.FileName = filepath.Base(stagedPath)
+		stagedTracks = append(stagedTracks, track)
 	}
+	return stagedTracks
 }
 
 // 移动至本地文件夹
 func moveToLocal(tickedTracks []util.MP3MetaInfo, playListName string) {
 	basePath := filepath.Join(spotifyLocalPath, playListName)
 	tempBasePath := filepath.Join(spotifyLocalTempPath, playListName)
-	mp3Files := make([]util.MP3MetaInfo, 0)
-	filepath.Walk(basePath, func(path string, info fs.FileInfo, err error) error {
-		//如果当前文件是mp3
-		if err != nil && info != nil && !info.IsDir() && strings.HasSuffix(info.Name(), ".mp3") {
-			metaInfo, err := util.ExtractMp3FromPath(path)
-			if err != nil {
-				//当前mp3处理失败下一个
-				return nil
-			}
-			mp3Files = append(mp3Files, metaInfo)
-		}
-		return err
-	})
+	//本地文件夹中已有的曲目
+	mp3Files := scanLibrary(basePath).Tracks
 	//	遍历unHandledTracks 如果存在和mp3Files中匹配的mp3文件就跳过
 	for _, track := range tickedTracks {
+		if track.LinkedFrom != "" {
+			continue
+		}
 		if flag, _ := isTrackInLocalTracks(track, mp3Files); flag {
 			continue
 		}
-		//移动到对应的临时文件夹
-		err := os.Rename(filepath.Join(tempBasePath, track.FileName), filepath.Join(basePath, track.FileName))
-		if err != nil {
-			_ = closeSpotifyProcess()
-			err := os.Rename(filepath.Join(tempBasePath, track.FileName), filepath.Join(basePath, track.FileName))
-			if err != nil {
-				fmt.Println("文件移动失败: ", err)
-				continue
-			}
+		//移回对应的本地文件夹 链接方式暂存的只删除链接
+		if err := unstageFile(filepath.Join(tempBasePath, track.FileName), filepath.Join(basePath, track.FileName)); err != nil {
+			fmt.Println("文件移动失败: ", err)
 		}
 	}
 }
 
-// 关闭spotify进程
-func closeSpotifyProcess() error {
-	cmd := exec.Command("taskkill", "/IM", "Spotify.exe", "/F")
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("关闭 Spotify 进程失败：%v", err)
-	}
-	return nil
-}
-
 // 获取进程的详细信息
 func getProcessInfo(processName string) (string, error) {
 	cmd := exec.Command("wmic", "process", "where", "name='"+processName+"'", "get", "ExecutablePath", "/format:list")
@@ -429,47 +403,36 @@ func handle(ctx context.Context, sp *spotify.Client) (success bool) {
 	}
 
 	//获取本地元数据
-	localMusicMetaData := getLocalMusicMetaData()
+	localMusicMetaData, localErrors := getLocalMusicMetaData()
 	//读取临时文件夹 放到serializeData中
-	serializeData := loadLocalTempMusic()
-
+	serializeData, tempErrors := loadLocalTempMusic()
+	//无法解析的文件单独报告 可选移入隔离文件夹
+	scanErrors := append(localErrors, tempErrors...)
+	if quarantineUnreadableMode {
+		scanErrors = quarantineUnreadable(scanErrors)
+	}
+	reportUnreadable(scanErrors)
+	//从旧编码转码过的标签单独报告 便于核对
+	//可选把从文件名推断的标签写入文件
+	if appConf.WriteInferredTags {
+		writeInferredTags(spotifyLocalPath, localMusicMetaData)
+		writeInferredTags(spotifyLocalTempPath, serializeData)
+	}
+	reportTranscoded(append(findTranscoded(spotifyLocalPath, localMusicMetaData), findTranscoded(spotifyLocalTempPath, serializeData)...))
+
+	//链接方式暂存的曲目 用于识别带后缀暂存的原文件
+	originals := linkedOriginals()
+	//歌单中本地文件已不存在的曲目
+	orphans := make(map[string][]playListLocalItem)
+	//各歌单的在线本地曲目
+	onlineTracks := make(map[string][]util.MP3MetaInfo)
+	//各歌单文件夹中未被同名歌单收录的曲目
+	unHandledData := make(map[string][]util.MP3MetaInfo)
 	//遍历歌单集合 过滤出本地  `未分类`  和   `分类错误的歌曲(以本地为准) 即能在本地文件夹找到 同时该mp3文件所属父文件夹的名称与当前歌单名称不一致`
 	for _, playList := range playLists {
 		//查询本地元数据 通过key = 歌单名称查询 是否在映射中存在
 		localTracks, ok := localMusicMetaData[playList.Name]
-		if ok {
-			//	key存在!
-			//根据playListId查询在线歌单的tracks
-			tracks, err := getTracksByPlayList(sp, ctx, playList)
-			if err != nil {
-				//如果获取歌单失败 处理下一个歌单
-				continue
-			}
-			//处理本地曲目localTracks和在线本地曲目tracks 过滤出满足条件的曲目路径集合
-			//将未分类的,分类错误的(以本地为准)本地文件移到spotify_local_temp文件夹
-			//打开spotify客户端 本地来源关闭spotify_local 新增spotify_local_temp
-			//分类完毕 再将本地来源改回去即可(关闭spotify_local_temp 新增spotify_local)
-
-			//if  len(tracks) == 0{
-			//	//本地的曲目需要全部同步过去
-			//	continue
-			//}
-			//if len(localTracks) == 0 {
-			//	// 说明spotify服务器以前同步了元数据 但是本地文件丢失 需要手动将服务器这部分文件元数据删除
-			//	continue
-			//}
-			unHandledTracks, _ := diffTracks(localTracks, tracks)
-			if len(unHandledTracks) != 0 {
-				//移动到temp文件夹
-				moveToTemp(unHandledTracks, playList.Name)
-				//如果serializeData存在歌单key 则选择加入
-				if data, ok := serializeData[playList.Name]; ok {
-					serializeData[playList.Name] = append(data, unHandledTracks...)
-				} else {
-					serializeData[playList.Name] = unHandledTracks
-				}
-			}
-		} else {
+		if !ok {
 			//本地音乐库不存在该歌单 创建该歌单文件夹
 			err := os.Mkdir(filepath.Join(spotifyLocalPath, playList.Name), 0755)
 			if err != nil {
@@ -477,18 +440,84 @@ func handle(ctx context.Context, sp *spotify.Client) (success bool) {
 			} else {
 				fmt.Printf("已创建本地歌单: %v", playList.Name)
 			}
+			continue
+		}
+		//根据playListId查询在线歌单的tracks
+		items, err := getLocalItemsByPlayList(sp, ctx, playList)
+		if err != nil {
+			//如果获取歌单失败 处理下一个歌单
+			continue
+		}
+		tracks := make([]util.MP3MetaInfo, 0, len(items))
+		for _, item := range items {
+			tracks = append(tracks, item.Track)
+		}
+		onlineTracks[playList.Name] = tracks
+		// spotify服务器以前同步了元数据 但是本地文件(包括暂存区)已丢失
+		existingTracks := make([]util.MP3MetaInfo, 0, len(localTracks)+len(serializeData[playList.Name]))
+		existingTracks = append(existingTracks, localTracks...)
+		existingTracks = append(existingTracks, serializeData[playList.Name]...)
+		if playListOrphans := findOrphans(items, existingTracks); len(playListOrphans) != 0 {
+			orphans[playList.Name] = playListOrphans
+		}
+		//处理本地曲目localTracks和在线本地曲目tracks 过滤出未被收录的曲目
+		unHandledTracks, _ := diffTracks(localTracks, tracks)
+		unHandledTracks = dropStaleMemberships(unHandledTracks)
+		unHandledTracks = dropStagedTracks(unHandledTracks, serializeData[playList.Name], originals)
+		if len(unHandledTracks) != 0 {
+			unHandledData[playList.Name] = unHandledTracks
 		}
 	}
-	uncateforizedFile, err := os.Create(filepath.Join(spotifyConfigBasePath, "uncategorized.json"))
-	if err != nil {
-		return false
+
+	//跨歌单比对 找出放错文件夹的曲目 以及同时属于多个歌单的曲目
+	misclassified, shared := findMisclassified(unHandledData, onlineTracks)
+	sharedFiles := resolveOrphans(orphans, localMusicMetaData, unHandledData)
+	if len(misclassified) != 0 || len(shared) != 0 || len(sharedFiles) != 0 {
+		reportMisclassified(misclassified, append(shared, sharedFiles...))
 	}
-	defer uncateforizedFile.Close()
-	//序列化成json到当前文件夹
-	encoder := json.NewEncoder(uncateforizedFile)
-	err = encoder.Encode(serializeData)
-	if err != nil {
-		fmt.Println("序列化数据失败: ", err)
+	if len(sharedFiles) != 0 && confirm(fmt.Sprintf("是否将以上%d首同时属于多个歌单的曲目记录到membership.json?", len(sharedFiles))) {
+		addMemberships(sharedFiles)
+	}
+	if len(misclassified) != 0 && confirm(fmt.Sprintf("是否将以上%d首分类错误的曲目移动到对应的歌单文件夹?", len(misclassified))) {
+		for _, membership := range moveMisclassified(misclassified) {
+			//已经移到正确文件夹的曲目不再暂存
+			remaining := make([]util.MP3MetaInfo, 0)
+			for _, track := range unHandledData[membership.Folder] {
+				if track.FileName != membership.Track.FileName {
+					remaining = append(remaining, track)
+				}
+			}
+			unHandledData[membership.Folder] = remaining
+		}
+	}
+	if len(orphans) != 0 {
+		reportOrphans(orphans)
+		if pruneOrphansMode {
+			pruneOrphans(ctx, sp, playLists, orphans)
+		}
+	}
+
+	//将未分类的,分类错误的(以本地为准)本地文件移到spotify_local_temp文件夹
+	//打开spotify客户端 本地来源关闭spotify_local 新增spotify_local_temp
+	//分类完毕 再将本地来源改回去即可(关闭spotify_local_temp 新增spotify_local)
+	for _, playListName := range sortedKeys(unHandledData) {
+		unHandledTracks := unHandledData[playListName]
+		if len(unHandledTracks) == 0 {
+			continue
+		}
+		//移动到temp文件夹 只记录实际暂存的曲目
+		stagedTracks := moveToTemp(unHandledTracks, playListName)
+		if len(stagedTracks) == 0 {
+			continue
+		}
+		//如果serializeData存在歌单key 则选择加入
+		if data, ok := serializeData[playListName]; ok {
+			serializeData[playListName] = append(data, stagedTracks...)
+		} else {
+			serializeData[playListName] = stagedTracks
+		}
+	}
+	if !saveUncategorizedFile(serializeData) {
 		return false
 	}
 	//打印曲目分类信息 [歌单名称]   [起始序号]   [终止序号]
@@ -496,7 +525,8 @@ func handle(ctx context.Context, sp *spotify.Client) (success bool) {
 	return
 }
 
-func getCategorizeStat(uncategorizedData map[string][]util.MP3MetaInfo, leftTracksChan chan map[string][]util.MP3MetaInfo, tickedTracksFilesChan chan []map[string]string, exitSignal chan struct{}) {
+// getCategorizeStat 轮询spotify歌单 统计分类进度 并更新分类预览页面的数据
+func getCategorizeStat(uncategorizedData map[string][]util.MP3MetaInfo, tickedTracksFilesChan chan []map[string]string, exitSignal chan struct{}) {
 	//创建uncategorizedData的深拷贝对象
 	copyUncategorizedData := make(map[string][]util.MP3MetaInfo)
 	for k, v := range uncategorizedData {
@@ -504,25 +534,58 @@ func getCategorizeStat(uncategorizedData map[string][]util.MP3MetaInfo, leftTrac
 	}
 	tickedTracksData := make([]map[string]string, 0)
 	ctx := context.Background()
-	config := &oauth2.Config{
-		ClientID:     spotifyClientID,
-		ClientSecret: spotifyClientSecret,
-		RedirectURL:  redirectURL,
-		Scopes:       scopes,
-		Endpoint: oauth2.Endpoint{
-			AuthURL:  spotifyauth.AuthURL,
-			TokenURL: spotifyauth.TokenURL,
-		},
-	}
-	token := <-tokenChan
-	client := config.Client(ctx, token)
-	sp := spotify.New(client)
+	sp := newSessionClient(ctx)
+	cache := newPlayListTracksCache()
 
 	for {
+		//并入监听模式下新暂存的曲目
+		for playListName, tracks := range takeStagedTracks() {
+			copyUncategorizedData[playListName] = append(copyUncategorizedData[playListName], tracks...)
+		}
+		//并入网页上修改过的标签
+		copyUncategorizedData = retagTracks(copyUncategorizedData, takeRetaggedTracks())
 		//每完成一个歌单的分类 就减少一个歌单的查询
 		newData := make(map[string][]util.MP3MetaInfo)
+		//查询失败(可能是受到了rate limit)时保留待分类的曲目 下一轮再试 不能直接退出 否则暂存的文件和本地文件来源都无法还原
+		if err := cache.refresh(ctx, sp); err != nil {
+			fmt.Println("查询歌单失败, 稍后重试: ", err)
+			for playListName, localTracks := range copyUncategorizedData {
+				newData[playListName] = localTracks
+			}
+		}
 		//遍历uncategorizedData临时文件夹
 		for playListName, localTracks := range copyUncategorizedData {
+			if _, ok := newData[playListName]; ok {
+				continue
+			}
+			if playListName == inboxStagingName {
+				//收件箱中未命中规则的曲目没有对应的歌单 加入了哪个歌单就移到哪个歌单文件夹
+				leftTracks := localTracks
+				for _, name := range sortedPlayListNames() {
+					tracks, err := cache.get(ctx, sp, name)
+					if err != nil {
+						fmt.Println("查询歌单曲目元信息失败, 稍后重试: ", err)
+						break
+					}
+					var tickedTracks []util.MP3MetaInfo
+					leftTracks, tickedTracks = diffTracks(leftTracks, tracks)
+					for _, track := range tickedTracks {
+						tickedTracksData = append(tickedTracksData, map[string]string{
+							"source": filepath.Join(spotifyLocalTempPath, inboxStagingName, track.FileName),
+							"dest":   filepath.Join(spotifyLocalPath, name, track.FileName),
+						})
+					}
+					if len(leftTracks) == 0 {
+						break
+					}
+				}
+				if len(leftTracks) != 0 {
+					newData[playListName] = leftTracks
+				} else {
+					delete(copyUncategorizedData, playListName)
+				}
+				continue
+			}
 			//根据歌单名称 在映射表里查询对应的歌单ID
 			playlistID, ok := playListMap[playListName]
 			if !ok || playlistID == "" {
@@ -530,11 +593,11 @@ func getCategorizeStat(uncategorizedData map[string][]util.MP3MetaInfo, leftTrac
 				continue
 			}
 			//根据歌单ID 查询spotify在线元数据 得到本地曲目元数据切片
-			tracks, err := getTracksByPlayList(sp, ctx, spotify.SimplePlaylist{ID: playlistID, Name: playListName})
+			tracks, err := cache.get(ctx, sp, playListName)
 			if err != nil {
-				//查询歌单曲目失败 可能是受到了rate limit
-				fmt.Println("查询歌单曲目元信息失败: ", err)
-				os.Exit(1)
+				fmt.Println("查询歌单曲目元信息失败, 稍后重试: ", err)
+				newData[playListName] = localTracks
+				continue
 			}
 			//已剔除的曲目
 
@@ -556,20 +619,55 @@ func getCategorizeStat(uncategorizedData map[string][]util.MP3MetaInfo, leftTrac
 				}
 			}
 		}
-		if len(newData) == 0 {
+		setUncategorized(newData)
+		saveUncategorizedFile(newData)
+		//监听模式下没有待分类曲目时继续等待新的下载
+		if len(newData) == 0 && !watchMode {
 			fmt.Println("分类已完成!")
-			go func() {
-				leftTracksChan <- newData
-				tickedTracksFilesChan <- tickedTracksData
-				exitSignal <- struct{}{}
-				tokenChan <- token
-			}()
+			tickedTracksFilesChan <- tickedTracksData
+			close(exitSignal)
 			break
 		}
-		leftTracksChan <- newData
-		time.Sleep(5 * time.Second)
+		select {
+		case <-stopWatch:
+			fmt.Println("已停止监听!")
+			tickedTracksFilesChan <- tickedTracksData
+			close(exitSignal)
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+}
+
+// sortedPlayListNames 按名称排序的有效歌单 收件箱的曲目同时加入多个歌单时归入排在前面的歌单文件夹
+func sortedPlayListNames() []string {
+	names := make([]string, 0, len(playListMap))
+	for name, id := range playListMap {
+		if id != "" {
+			names = append(names, name)
+		}
 	}
+	sort.Strings(names)
+	return names
+}
 
+// saveUncategorizedFile 将待分类曲目序列化到uncategorized.json
+func saveUncategorizedFile(data map[string][]util.MP3MetaInfo) bool {
+	uncategorizedFile, err := os.Create(filepath.Join(spotifyConfigBasePath, "uncategorized.json"))
+	if err != nil {
+		fmt.Println("无法创建uncategorized.json: ", err)
+		return false
+	}
+	defer uncategorizedFile.Close()
+	//序列化成json到配置文件夹
+	encoder := json.NewEncoder(uncategorizedFile)
+	err = encoder.Encode(data)
+	if err != nil {
+		fmt.Println("序列化数据失败: ", err)
+		return false
+	}
+	return true
 }
 
 // 移动文件
@@ -583,30 +681,16 @@ func postProcess(tickedTracksFilesChan chan []map[string]string) {
 		} else {
 			sourceRecover = append(sourceRecover, source)
 		}
-		dest := item["dest"]
-		err := os.Rename(source, dest)
-		if err != nil {
-			needSpotifyRecover = true
-			_ = closeSpotifyProcess()
-			err = os.Rename(source, dest)
-			if err != nil {
-				fmt.Println("移动文件失败: ", err)
-				os.Exit(1)
-			}
+		//失败的文件留在临时文件夹 下次运行时会重新分类
+		if err := unstageFile(source, item["dest"]); err != nil {
+			fmt.Println("移动文件失败: ", err)
 		}
 	}
+	resolvePendingConflicts()
+	reportConflicts()
+	//还原本地文件来源 需要时会关闭spotify
+	restoreLocalSources()
 	if needSpotifyRecover {
-		// 获取 Spotify 进程的详细信息
-		if spotifyAppPath == "" {
-			fmt.Println("Spotify.exe process is not found")
-			return
-		}
-		cmd := exec.Command(spotifyAppPath)
-		err := cmd.Start()
-		if err != nil {
-			fmt.Println("打开Spotify失败: ", err)
-			return
-		}
+		openSpotify()
 	}
-
 }
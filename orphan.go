@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+	"github.com/zmb3/spotify/v2"
+)
+
+// findOrphans 找出歌单中已经没有对应本地文件的本地曲目
+// spotify会一直保留本地文件的元数据 即使文件已经被删除
+func findOrphans(items []playListLocalItem, localTracks []util.MP3MetaInfo) []playListLocalItem {
+	orphans := make([]playListLocalItem, 0)
+	for _, item := range items {
+		if flag, _ := isTrackInLocalTracks(item.Track, localTracks); !flag {
+			orphans = append(orphans, item)
+		}
+	}
+	return orphans
+}
+
+// reportOrphans 按歌单打印孤儿曲目 并写入orphans.json
+func reportOrphans(orphans map[string][]playListLocalItem) {
+	for playListName, items := range orphans {
+		fmt.Printf("歌单: %v 有%d首本地曲目在文件夹中不存在:\n", playListName, len(items))
+		for _, item := range items {
+			fmt.Printf("  #%d %s - %s (%s)\n", item.Position+1, item.Track.Artist, item.Track.Title, item.Track.Album)
+		}
+	}
+	orphansFile, err := os.Create(filepath.Join(spotifyConfigBasePath, "orphans.json"))
+	if err != nil {
+		fmt.Println("无法创建orphans.json: ", err)
+		return
+	}
+	defer orphansFile.Close()
+	encoder := json.NewEncoder(orphansFile)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(orphans)
+}
+
+// pruneOrphans 逐个歌单确认后 通过Web API按位置和快照ID移除孤儿曲目
+// 指定快照ID后 如果歌单在此期间被修改导致位置对不上 spotify会拒绝整个请求 不会误删
+func pruneOrphans(ctx context.Context, sp *spotify.Client, playLists []spotify.SimplePlaylist, orphans map[string][]playListLocalItem) {
+	for _, playList := range playLists {
+		items, ok := orphans[playList.Name]
+		if !ok || len(items) == 0 {
+			continue
+		}
+		if !confirm(fmt.Sprintf("是否从歌单: %v 中移除以上%d首不存在的本地曲目?", playList.Name, len(items))) {
+			continue
+		}
+		//同一个URI可能出现在多个位置
+		positions := make(map[spotify.URI][]int)
+		uris := make([]spotify.URI, 0)
+		for _, item := range items {
+			if _, ok := positions[item.URI]; !ok {
+				uris = append(uris, item.URI)
+			}
+			positions[item.URI] = append(positions[item.URI], item.Position)
+		}
+		tracks := make([]spotify.TrackToRemove, 0, len(uris))
+		for _, uri := range uris {
+			tracks = append(tracks, spotify.TrackToRemove{URI: string(uri), Positions: positions[uri]})
+		}
+		_, err := sp.RemoveTracksFromPlaylistOpt(ctx, playList.ID, tracks, playList.SnapshotID)
+		if err != nil {
+			fmt.Printf("歌单: %v 移除失败: %v\n", playList.Name, err)
+			continue
+		}
+		fmt.Printf("歌单: %v 已移除%d首不存在的本地曲目\n", playList.Name, len(items))
+	}
+}
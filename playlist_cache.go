@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+	"github.com/zmb3/spotify/v2"
+)
+
+// playListTracksCache 分类期间缓存各歌单中的本地曲目 歌单的snapshot_id没有变化时不再查询曲目
+// 收件箱的曲目需要和所有歌单比对 不缓存时每轮都要查询所有歌单的曲目 很容易受到rate limit
+type playListTracksCache struct {
+	//歌单ID => 缓存曲目时的snapshot_id
+	snapshots map[spotify.ID]string
+	//歌单ID => 本地曲目
+	tracks map[spotify.ID][]util.MP3MetaInfo
+	//本轮查询到的各歌单最新的snapshot_id
+	current map[spotify.ID]string
+}
+
+func newPlayListTracksCache() *playListTracksCache {
+	return &playListTracksCache{
+		snapshots: make(map[spotify.ID]string),
+		tracks:    make(map[spotify.ID][]util.MP3MetaInfo),
+		current:   make(map[spotify.ID]string),
+	}
+}
+
+// refresh 查询所有歌单当前的snapshot_id 每轮开始时调用一次 分页查询 歌单再多也只需要几次请求
+func (cache *playListTracksCache) refresh(ctx context.Context, sp *spotify.Client) error {
+	current := make(map[spotify.ID]string)
+	page, err := sp.CurrentUsersPlaylists(ctx, spotify.Limit(50))
+	for err == nil {
+		for _, playList := range page.Playlists {
+			current[playList.ID] = playList.SnapshotID
+		}
+		err = sp.NextPage(ctx, page)
+	}
+	if !errors.Is(err, spotify.ErrNoMorePages) {
+		return err
+	}
+	cache.current = current
+	return nil
+}
+
+// get 歌单中的本地曲目 没有缓存或snapshot_id变化时重新查询
+func (cache *playListTracksCache) get(ctx context.Context, sp *spotify.Client, playListName string) ([]util.MP3MetaInfo, error) {
+	id := playListMap[playListName]
+	snapshot := cache.current[id]
+	if tracks, ok := cache.tracks[id]; ok && snapshot != "" && cache.snapshots[id] == snapshot {
+		return tracks, nil
+	}
+	tracks, err := getTracksByPlayList(sp, ctx, spotify.SimplePlaylist{ID: id, Name: playListName})
+	if err != nil {
+		return nil, err
+	}
+	cache.tracks[id] = tracks
+	cache.snapshots[id] = snapshot
+	return tracks, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// restoreItem 一个需要还原的暂存文件
+type restoreItem struct {
+	//暂存区中的路径
+	Staged string
+	//还原到的路径
+	Original string
+	//来源 journal: 暂存日志 uncategorized: uncategorized.json folder: 按所在的歌单文件夹推断
+	Source string
+	//暂存日志中的记录 只有来源为journal时有
+	entry *stagingEntry
+}
+
+// runRestore 放弃本次分类 把暂存区的文件全部放回原来的歌单文件夹
+// 优先使用暂存日志 其次uncategorized.json 都没有记录的文件按所在的歌单文件夹放回
+// -dry-run: 只打印将要执行的操作
+func runRestore(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "只打印将要还原的文件 不实际移动")
+	_ = flags.Parse(args)
+
+	items := planRestore()
+	if len(items) == 0 {
+		fmt.Println("暂存区没有需要还原的文件")
+	}
+	failed := 0
+	for _, item := range items {
+		fmt.Printf("[%v] %v => %v\n", item.Source, item.Staged, item.Original)
+		if *dryRun {
+			continue
+		}
+		if err := restoreFile(item); err != nil {
+			fmt.Println("  还原失败: ", err)
+			failed++
+		}
+	}
+	if *dryRun {
+		return
+	}
+	fmt.Printf("已还原%d个文件, 失败%d个\n", len(items)-failed, failed)
+	if failed != 0 {
+		//保留会话状态 修复问题后可以再次还原
+		fmt.Println("有文件还原失败, 保留暂存日志和uncategorized.json")
+		return
+	}
+	clearSessionState()
+	//切换过的本地文件来源也一并还原
+	restoreLocalSources()
+}
+
+// planRestore 列出暂存区中所有文件及其原来的位置
+func planRestore() []restoreItem {
+	items := make([]restoreItem, 0)
+	planned := make(map[string]bool)
+	stagingJournalMutex.Lock()
+	entries := loadStagingJournal()
+	stagingJournalMutex.Unlock()
+	for i := range entries {
+		if _, err := os.Lstat(entries[i].Staged); err != nil {
+			continue
+		}
+		items = append(items, restoreItem{Staged: entries[i].Staged, Original: entries[i].Original, Source: "journal", entry: &entries[i]})
+		planned[entries[i].Staged] = true
+	}
+
+	uncategorizedData := make(map[string][]util.MP3MetaInfo)
+	if uncategorizedFile, err := os.Open(filepath.Join(spotifyConfigBasePath, "uncategorized.json")); err == nil {
+		_ = json.NewDecoder(uncategorizedFile).Decode(&uncategorizedData)
+		_ = uncategorizedFile.Close()
+	}
+	for _, playListName := range sortedKeys(uncategorizedData) {
+		for _, track := range uncategorizedData[playListName] {
+			staged := filepath.Join(spotifyLocalTempPath, playListName, track.FileName)
+			if track.LinkedFrom != "" || planned[staged] {
+				continue
+			}
+			if _, err := os.Lstat(staged); err != nil {
+				continue
+			}
+			items = append(items, restoreItem{Staged: staged, Original: filepath.Join(originalFolder(playListName), track.FileName), Source: "uncategorized"})
+			planned[staged] = true
+		}
+	}
+
+	//没有记录的文件: 收件箱暂存的放回收件箱 其他的放回同名的歌单文件夹
+	folderItems := make([]restoreItem, 0)
+	_ = filepath.WalkDir(spotifyLocalTempPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || planned[path] || !strings.HasSuffix(strings.ToLower(entry.Name()), ".mp3") {
+			return nil
+		}
+		rel, err := filepath.Rel(spotifyLocalTempPath, path)
+		if err != nil {
+			return nil
+		}
+		original := filepath.Join(spotifyLocalPath, rel)
+		if parts := strings.SplitN(filepath.ToSlash(rel), "/", 2); len(parts) == 2 {
+			original = filepath.Join(originalFolder(parts[0]), filepath.FromSlash(parts[1]))
+		}
+		folderItems = append(folderItems, restoreItem{Staged: path, Original: original, Source: "folder"})
+		return nil
+	})
+	sort.Slice(folderItems, func(i, j int) bool {
+		return folderItems[i].Staged < folderItems[j].Staged
+	})
+	return append(items, folderItems...)
+}
+
+// originalFolder 暂存区文件夹对应的原文件夹 收件箱暂存的曲目放回收件箱
+func originalFolder(playListName string) string {
+	if playListName == inboxStagingName {
+		return spotifyInboxPath
+	}
+	return filepath.Join(spotifyLocalPath, playListName)
+}
+
+// restoreFile 还原一个文件并校验结果
+func restoreFile(item restoreItem) error {
+	if item.entry != nil && item.entry.Kind != stagingMove {
+		//链接方式暂存的 原文件还在原处 删除链接即可
+		if _, err := os.Stat(item.Original); err != nil {
+			if item.entry.Kind == stagingSymlink {
+				return fmt.Errorf("原文件不存在, 保留软链接: %w", err)
+			}
+			//原文件被删除了 硬链接本身就是完整的文件
+			return restoreByMove(item)
+		}
+		if err := os.Remove(item.Staged); err != nil {
+			return err
+		}
+		_, _ = takeStaged(item.Staged)
+		return nil
+	}
+	return restoreByMove(item)
+}
+
+// restoreByMove 把暂存的文件移回原处 移动后校验大小
+func restoreByMove(item restoreItem) error {
+	stagedInfo, err := os.Stat(item.Staged)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(item.Original), os.ModeDir); err != nil {
+		return err
+	}
+	finalPath, err := moveFile(item.Staged, item.Original)
+	if err != nil {
+		return err
+	}
+	finalInfo, err := os.Stat(finalPath)
+	if err != nil {
+		return fmt.Errorf("移动后找不到文件: %w", err)
+	}
+	if finalInfo.Size() != stagedInfo.Size() {
+		return fmt.Errorf("移动后文件大小不一致: %v", finalPath)
+	}
+	if _, err := os.Lstat(item.Staged); err == nil {
+		return fmt.Errorf("移动后暂存区中仍有文件: %v", item.Staged)
+	}
+	if finalPath != item.Original {
+		fmt.Println("  原位置已有同名文件, 已还原为: ", finalPath)
+	}
+	if item.entry != nil {
+		_, _ = takeStaged(item.Staged)
+	}
+	return nil
+}
+
+// clearSessionState 清除本次分类的状态: 待分类列表 暂存日志和暂存区中的空文件夹
+func clearSessionState() {
+	for _, name := range []string{"uncategorized.json", "staging.json"} {
+		if err := os.Remove(filepath.Join(spotifyConfigBasePath, name)); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("无法删除%v: %v\n", name, err)
+		}
+	}
+	folders := make([]string, 0)
+	_ = filepath.WalkDir(spotifyLocalTempPath, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.IsDir() && path != spotifyLocalTempPath {
+			folders = append(folders, path)
+		}
+		return nil
+	})
+	//先删除深层的文件夹 非空的文件夹删除失败会被忽略
+	sort.Sort(sort.Reverse(sort.StringSlice(folders)))
+	for _, folder := range folders {
+		_ = os.Remove(folder)
+	}
+	fmt.Println("已清除本次分类的状态")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newRouter 创建唯一的路由 授权回调 分类预览页面和接口都挂在这里
+func newRouter() *gin.Engine {
+	router := gin.Default()
+	//spotify授权回调 由spotify跳转而来 不校验访问令牌 由OAuth state保证安全
+	router.GET("/callback", handleCallback)
+
+	//拒绝跨域请求 并要求携带本次会话的访问令牌
+	ui := router.Group("/", sameOriginGuard(), accessTokenGuard())
+	// 路由到 index.html
+	ui.GET("/", serveIndex)
+	// 路由到 jsonview.js
+	ui.GET("/static/js/jsonview.js", serveJsonView)
+	//查询分类信息
+	ui.GET("/uncategorized", serveUncategorized)
+	//无法解析的文件
+	ui.GET("/unreadable", func(c *gin.Context) {
+		c.JSON(http.StatusOK, getUnreadable())
+	})
+	//试听暂存区的曲目
+	ui.GET("/audio/*filepath", serveStagedAudio)
+	//查看和修改暂存区曲目的标签 ?preview=1时只返回差异
+	ui.GET("/tags/*filepath", serveTrackTags)
+	ui.POST("/tags/*filepath", editTrackTags)
+	//移动文件时的冲突 策略为ask时在这里决定
+	ui.GET("/conflicts", serveConflicts)
+	ui.POST("/conflicts/:id", handleConflict)
+	//文件被占用时是否关闭spotify 策略为ask-ui时在这里回答
+	ui.GET("/spotify/close", serveSpotifyClose)
+	ui.POST("/spotify/close", answerSpotifyClose)
+	//导出歌单文件夹或待分类曲目 name为歌单名或uncategorized ?format=m3u8/xspf/csv ?absolute=0时使用相对路径
+	ui.GET("/export/:name", serveExport)
+	return router
+}
+
+// 首页
+func serveIndex(c *gin.Context) {
+	content, err := htmlFile.ReadFile("static/index.html")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", content)
+}
+
+// jsonview.js
+func serveJsonView(c *gin.Context) {
+	content, err := jsFile.ReadFile("static/js/jsonview.js")
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	c.Data(http.StatusOK, "application/javascript", content)
+}
+
+// 查询待分类的曲目 分类阶段开始之前返回503
+func serveUncategorized(c *gin.Context) {
+	data, ok := getUncategorized()
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "处理中"})
+		return
+	}
+	if watchMode {
+		//监听模式下列表为空也不关闭页面
+		c.Header("X-Watch-Mode", "1")
+	}
+	c.JSON(http.StatusOK, data)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// scanError 无法解析的文件
+type scanError struct {
+	//文件路径
+	Path string
+	//失败原因 见util.Reason*
+	Reason string
+	//错误信息
+	Err string
+}
+
+// scanResult 扫描结果
+type scanResult struct {
+	//根目录下所有子文件夹的名称
+	Folders []string
+	//解析成功的曲目 按路径排序
+	Tracks []util.MP3MetaInfo
+	//解析失败的文件 按路径排序
+	Errors []scanError
+}
+
+// 单个文件的解析结果
+type scanItem struct {
+	path string
+	meta util.MP3MetaInfo
+	err  error
+}
+
+// scanLibrary 扫描目录中的mp3文件
+// 一个协程遍历目录 固定数量的协程并发解析标签 最后按路径排序合并 保证结果稳定
+func scanLibrary(root string) scanResult {
+	result := scanResult{
+		Folders: make([]string, 0),
+		Tracks:  make([]util.MP3MetaInfo, 0),
+		Errors:  make([]scanError, 0),
+	}
+	paths := make(chan string)
+	items := make(chan scanItem)
+
+	//遍历目录
+	go func() {
+		defer close(paths)
+		_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if path != root {
+					items <- scanItem{path: path, err: err}
+				}
+				return nil
+			}
+			if entry.IsDir() {
+				if path != root {
+					result.Folders = append(result.Folders, entry.Name())
+				}
+				return nil
+			}
+			if strings.HasSuffix(strings.ToLower(entry.Name()), ".mp3") {
+				paths <- path
+			}
+			return nil
+		})
+	}()
+
+	//并发解析
+	workers := &sync.WaitGroup{}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for path := range paths {
+				info, err := os.Stat(path)
+				if err != nil {
+					items <- scanItem{path: path, err: err}
+					continue
+				}
+				meta, err := getLibraryIndex().extract(path, info)
+				//标签缺失的字段从文件名推断 不写入索引 修改模板后立即生效
+				meta, err = inferMissingTags(path, meta, err)
+				items <- scanItem{path: path, meta: meta, err: err}
+			}
+		}()
+	}
+	go func() {
+		workers.Wait()
+		close(items)
+	}()
+
+	//收集结果
+	collected := make([]scanItem, 0)
+	for item := range items {
+		collected = append(collected, item)
+	}
+	sort.Slice(collected, func(i, j int) bool {
+		return collected[i].path < collected[j].path
+	})
+	for _, item := range collected {
+		if item.err != nil {
+			reason := util.ReasonUnreadable
+			var mp3Err *util.Mp3Error
+			if errors.As(item.err, &mp3Err) {
+				reason = mp3Err.Reason
+			}
+			result.Errors = append(result.Errors, scanError{Path: item.path, Reason: reason, Err: item.err.Error()})
+		} else {
+			result.Tracks = append(result.Tracks, item.meta)
+		}
+	}
+	sort.Strings(result.Folders)
+	return result
+}
+
+// groupByPlayList 按歌单文件夹分组 每个子文件夹都有对应的键(可能为空切片)
+func (result scanResult) groupByPlayList() map[string][]util.MP3MetaInfo {
+	res := make(map[string][]util.MP3MetaInfo)
+	for _, folder := range result.Folders {
+		res[folder] = make([]util.MP3MetaInfo, 0)
+	}
+	for _, track := range result.Tracks {
+		if tracks, ok := res[track.PlayListName]; ok {
+			res[track.PlayListName] = append(tracks, track)
+		}
+	}
+	return res
+}
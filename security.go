@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 存放访问令牌的cookie名称
+const accessTokenCookie = "slm_token"
+
+var (
+	//本次会话的访问令牌 每次启动重新生成
+	accessToken = util.GenerateRandString(32)
+	//当前登录尝试的OAuth state 每次打开授权URL都会重新生成
+	authState string
+	//authState的锁
+	authStateMutex sync.Mutex
+)
+
+// listenHost 配置的监听地址 默认为127.0.0.1
+func listenHost() string {
+	if appConf.ListenHost == "" {
+		return "127.0.0.1"
+	}
+	return appConf.ListenHost
+}
+
+// listenAddr web服务的监听地址 默认为127.0.0.1:端口
+func listenAddr() string {
+	host := listenHost()
+	if ip := net.ParseIP(host); (ip == nil && host != "localhost") || (ip != nil && !ip.IsLoopback()) {
+		fmt.Printf("注意: web服务将监听在非本机地址 %s 上!\n", host)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(listenPort))
+}
+
+// newAuthState 为新的登录尝试生成state 旧的state随即失效
+func newAuthState() string {
+	authStateMutex.Lock()
+	defer authStateMutex.Unlock()
+	authState = util.GenerateRandString(16)
+	return authState
+}
+
+// consumeAuthState 校验回调中的state 校验通过后立即作废 防止重放
+func consumeAuthState(actual string) bool {
+	authStateMutex.Lock()
+	defer authStateMutex.Unlock()
+	if authState == "" || subtle.ConstantTimeCompare([]byte(authState), []byte(actual)) != 1 {
+		return false
+	}
+	authState = ""
+	return true
+}
+
+// isValidAccessToken 校验访问令牌
+func isValidAccessToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(accessToken)) == 1
+}
+
+// sessionURL 携带访问令牌的首页地址 用于在浏览器中打开
+// 监听在所有地址(0.0.0.0或::)时通过本机回环地址访问 IPv6地址加方括号
+func sessionURL() string {
+	host := listenHost()
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	return fmt.Sprintf("http://%s/?token=%s", net.JoinHostPort(host, strconv.Itoa(listenPort)), accessToken)
+}
+
+// sameOriginGuard 拒绝跨域请求
+func sameOriginGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Sec-Fetch-Site") == "cross-site" {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		if origin := c.GetHeader("Origin"); origin != "" && origin != "http://"+c.Request.Host {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// accessTokenGuard 校验访问令牌 令牌可以来自cookie或者X-Access-Token请求头
+// 首页允许通过?token=参数携带令牌 校验通过后写入cookie
+func accessTokenGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("token"); token != "" && c.Request.URL.Path == "/" {
+			if !isValidAccessToken(token) {
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+			c.SetSameSite(http.SameSiteStrictMode)
+			c.SetCookie(accessTokenCookie, token, 0, "/", "", false, true)
+			//去掉地址栏里的令牌
+			c.Redirect(http.StatusFound, "/")
+			c.Abort()
+			return
+		}
+		token, _ := c.Cookie(accessTokenCookie)
+		if token == "" {
+			token = c.GetHeader("X-Access-Token")
+		}
+		if !isValidAccessToken(token) {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
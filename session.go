@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+	"github.com/zmb3/spotify/v2"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
+)
+
+var (
+	//会话状态的锁
+	sessionMutex sync.RWMutex
+	//当前会话使用的token
+	sessionToken *oauth2.Token
+	//待分类的曲目 nil表示分类阶段尚未开始
+	uncategorized map[string][]util.MP3MetaInfo
+	//业务处理完成信号 只会被关闭一次
+	authDone = make(chan struct{})
+	//保证authDone只关闭一次
+	authDoneOnce sync.Once
+	//监听模式下新暂存 尚未并入分类统计的曲目
+	stagedTracks = make(map[string][]util.MP3MetaInfo)
+	//监听模式的停止信号
+	stopWatch = make(chan struct{})
+	//无法解析的文件
+	unreadableFiles = make([]scanError, 0)
+	//网页上修改过标签 尚未并入分类统计的曲目 键为 歌单名/文件名
+	retaggedTracks = make(map[string]util.TagFields)
+	//分类预览页面是否已打开且仍在分类中 只有这段时间可以在页面中询问
+	pageSessionActive bool
+)
+
+// newSessionClient 使用当前会话的token创建spotify客户端
+func newSessionClient(ctx context.Context) *spotify.Client {
+	config := &oauth2.Config{
+		ClientID:     spotifyClientID,
+		ClientSecret: spotifyClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  spotifyauth.AuthURL,
+			TokenURL: spotifyauth.TokenURL,
+		},
+	}
+	return spotify.New(config.Client(ctx, getSessionToken()))
+}
+
+// setSessionToken 记录当前会话的token
+func setSessionToken(token *oauth2.Token) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	sessionToken = token
+}
+
+// getSessionToken 获取当前会话的token
+func getSessionToken() *oauth2.Token {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return sessionToken
+}
+
+// setUncategorized 更新待分类的曲目
+func setUncategorized(data map[string][]util.MP3MetaInfo) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	uncategorized = data
+}
+
+// getUncategorized 获取待分类的曲目 第二个返回值表示分类阶段是否已开始
+func getUncategorized() (map[string][]util.MP3MetaInfo, bool) {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return uncategorized, uncategorized != nil
+}
+
+// finishAuth 通知主协程业务处理已完成 重复调用无副作用
+func finishAuth() {
+	authDoneOnce.Do(func() {
+		close(authDone)
+	})
+}
+
+// addStagedTracks 记录监听模式下新暂存的曲目
+func addStagedTracks(playListName string, tracks []util.MP3MetaInfo) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	stagedTracks[playListName] = append(stagedTracks[playListName], tracks...)
+}
+
+// takeStagedTracks 取出并清空新暂存的曲目
+func takeStagedTracks() map[string][]util.MP3MetaInfo {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	res := stagedTracks
+	stagedTracks = make(map[string][]util.MP3MetaInfo)
+	return res
+}
+
+// setUnreadable 记录无法解析的文件
+func setUnreadable(files []scanError) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	unreadableFiles = files
+}
+
+// getUnreadable 获取无法解析的文件
+func getUnreadable() []scanError {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return unreadableFiles
+}
+
+// addRetaggedTrack 记录网页上修改过标签的曲目 同时更新页面显示的待分类曲目
+func addRetaggedTrack(playListName string, fileName string, fields util.TagFields) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	key := playListName + "/" + fileName
+	retaggedTracks[key] = fields
+	if uncategorized != nil {
+		uncategorized = retagTracks(uncategorized, map[string]util.TagFields{key: fields})
+	}
+}
+
+// takeRetaggedTracks 取出并清空修改过标签的曲目
+func takeRetaggedTracks() map[string]util.TagFields {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	res := retaggedTracks
+	retaggedTracks = make(map[string]util.TagFields)
+	return res
+}
+
+// setPageSessionActive 标记分类预览页面会话的开始和结束
+func setPageSessionActive(active bool) {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	pageSessionActive = active
+}
+
+// isPageSessionActive 分类预览页面会话是否进行中
+func isPageSessionActive() bool {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return pageSessionActive
+}
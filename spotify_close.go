@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 文件被占用时是否关闭spotify
+const (
+	//从不关闭 重试后仍被占用时放弃移动
+	spotifyCloseNever = "never"
+	//重试后仍被占用时在控制台询问
+	spotifyCloseAsk = "ask"
+	//重试后仍被占用时在分类预览页面中询问
+	spotifyCloseAskUI = "ask-ui"
+	//重试SpotifyCloseRetries次后仍被占用时直接关闭
+	spotifyCloseKill = "kill"
+)
+
+const (
+	//正常关闭后等待spotify退出的时间 超时后强制结束
+	spotifyGracefulTimeout = 5 * time.Second
+	//在网页上询问时等待回答的时间 超时视为不关闭
+	spotifyAskTimeout = 2 * time.Minute
+)
+
+var (
+	//spotifyClose的锁 同一时间只处理一次关闭
+	spotifyCloseMutex sync.Mutex
+	//用户已拒绝关闭 本次运行不再询问
+	spotifyCloseDeclined bool
+	//网页上待回答的关闭请求 没有时为nil
+	spotifyClosePending *spotifyCloseRequest
+	//spotifyClosePending的锁
+	spotifyClosePendingMutex sync.Mutex
+)
+
+// spotifyCloseRequest 等待网页回答的关闭请求
+type spotifyCloseRequest struct {
+	//被占用的文件及错误
+	Reason string
+	//回答 true为同意关闭
+	answer chan bool
+}
+
+// spotifyClosePolicy 配置的关闭策略 无效时使用never
+func spotifyClosePolicy() string {
+	switch appConf.SpotifyClosePolicy {
+	case spotifyCloseAsk, spotifyCloseAskUI, spotifyCloseKill:
+		return appConf.SpotifyClosePolicy
+	default:
+		return spotifyCloseNever
+	}
+}
+
+// wantCloseSpotify 文件已被占用并重试了retries次 是否到了尝试关闭spotify的时候
+func wantCloseSpotify(retries int) bool {
+	switch spotifyClosePolicy() {
+	case spotifyCloseKill:
+		return retries >= min(max(appConf.SpotifyCloseRetries, 0), len(lockRetryDelays))
+	case spotifyCloseAsk, spotifyCloseAskUI:
+		return retries == len(lockRetryDelays)
+	default:
+		return false
+	}
+}
+
+// closeSpotifyForLock 文件一直被占用时按策略关闭spotify 返回true表示spotify已被本工具关闭 可以再试
+// spotify没有运行时说明占用文件的是其他程序 不会关闭
+func closeSpotifyForLock(lockErr error) bool {
+	spotifyCloseMutex.Lock()
+	defer spotifyCloseMutex.Unlock()
+	if needSpotifyRecover {
+		//之前已经关闭过
+		return true
+	}
+	if spotifyCloseDeclined || !isSpotifyRunning() {
+		return false
+	}
+	if !closeSpotify(fmt.Sprintf("文件被占用: %v", lockErr)) {
+		spotifyCloseDeclined = true
+		return false
+	}
+	return true
+}
+
+// requestCloseSpotify 按策略关闭正在运行的spotify(never时不关闭) 返回true表示已关闭
+func requestCloseSpotify(reason string) bool {
+	spotifyCloseMutex.Lock()
+	defer spotifyCloseMutex.Unlock()
+	if !isSpotifyRunning() {
+		return true
+	}
+	return closeSpotify(reason)
+}
+
+// closeSpotify 按策略询问后关闭spotify 关闭成功后处理完成时会重新打开 调用方需持有spotifyCloseMutex
+func closeSpotify(reason string) bool {
+	switch spotifyClosePolicy() {
+	case spotifyCloseAsk:
+		if !confirm(reason + ", 是否关闭Spotify?") {
+			return false
+		}
+	case spotifyCloseAskUI:
+		//没有打开分类预览页面时(如restore或分类已结束)在控制台询问
+		if isPageSessionActive() {
+			if !askCloseSpotifyInUI(reason) {
+				return false
+			}
+		} else if !confirm(reason + ", 是否关闭Spotify?") {
+			return false
+		}
+	case spotifyCloseKill:
+	default:
+		return false
+	}
+	//记住spotify的路径 处理完成后重启
+	if spotifyAppPath == "" {
+		if spotifyInfo, err := getProcessInfo("Spotify.exe"); err == nil {
+			spotifyAppPath = strings.ReplaceAll(extractFilePath(spotifyInfo), "\r", "")
+		}
+	}
+	if err := closeSpotifyProcess(); err != nil {
+		fmt.Println(err)
+		return false
+	}
+	fmt.Println(reason + ", 已关闭Spotify, 处理完成后会重新打开")
+	needSpotifyRecover = true
+	return true
+}
+
+// askCloseSpotifyInUI 在分类预览页面中询问是否关闭spotify 超时视为不关闭
+func askCloseSpotifyInUI(reason string) bool {
+	request := &spotifyCloseRequest{Reason: reason, answer: make(chan bool, 1)}
+	spotifyClosePendingMutex.Lock()
+	spotifyClosePending = request
+	spotifyClosePendingMutex.Unlock()
+	defer func() {
+		spotifyClosePendingMutex.Lock()
+		spotifyClosePending = nil
+		spotifyClosePendingMutex.Unlock()
+	}()
+	fmt.Println(reason + ", 请在分类预览页面中决定是否关闭Spotify")
+	select {
+	case allow := <-request.answer:
+		return allow
+	case <-time.After(spotifyAskTimeout):
+		fmt.Println("等待超时, 不关闭Spotify")
+		return false
+	}
+}
+
+// isSpotifyRunning spotify是否正在运行 非windows系统始终返回false
+func isSpotifyRunning() bool {
+	output, err := exec.Command("tasklist", "/FI", "IMAGENAME eq Spotify.exe", "/NH").Output()
+	return err == nil && strings.Contains(strings.ToLower(string(output)), "spotify.exe")
+}
+
+// closeSpotifyProcess 关闭spotify 先正常关闭 超时仍未退出再强制结束
+func closeSpotifyProcess() error {
+	if err := exec.Command("taskkill", "/IM", "Spotify.exe").Run(); err == nil && waitSpotifyExit(spotifyGracefulTimeout) {
+		return nil
+	}
+	if err := exec.Command("taskkill", "/IM", "Spotify.exe", "/F").Run(); err != nil {
+		return fmt.Errorf("关闭 Spotify 进程失败：%v", err)
+	}
+	if !waitSpotifyExit(spotifyGracefulTimeout) {
+		return fmt.Errorf("关闭 Spotify 进程失败：进程仍在运行")
+	}
+	return nil
+}
+
+// waitSpotifyExit 等待spotify退出 超时返回false
+func waitSpotifyExit(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for isSpotifyRunning() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return true
+}
+
+// serveSpotifyClose 查询网页上待回答的关闭请求
+func serveSpotifyClose(c *gin.Context) {
+	spotifyClosePendingMutex.Lock()
+	defer spotifyClosePendingMutex.Unlock()
+	if spotifyClosePending == nil {
+		c.JSON(http.StatusOK, gin.H{"pending": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pending": true, "reason": spotifyClosePending.Reason})
+}
+
+// spotifyCloseAnswer 网页上的回答
+type spotifyCloseAnswer struct {
+	//是否同意关闭spotify
+	Allow bool
+}
+
+// answerSpotifyClose 回答网页上的关闭请求
+func answerSpotifyClose(c *gin.Context) {
+	var answer spotifyCloseAnswer
+	if err := c.ShouldBindJSON(&answer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "参数无效"})
+		return
+	}
+	spotifyClosePendingMutex.Lock()
+	defer spotifyClosePendingMutex.Unlock()
+	if spotifyClosePending == nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "没有待回答的请求"})
+		return
+	}
+	select {
+	case spotifyClosePending.answer <- answer.Allow:
+	default:
+	}
+	c.JSON(http.StatusOK, gin.H{"allow": answer.Allow})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 默认的本地文件来源在prefs文件中的键 不同版本的客户端可能不同 可在配置文件中修改
+const defaultPrefsSourcesKey = "app.local-files.sources"
+
+// spotifyPrefsPath spotify的prefs文件路径 为空时使用%APPDATA%\Spotify\prefs
+func spotifyPrefsPath() string {
+	if appConf.SpotifyPrefsPath != "" {
+		return appConf.SpotifyPrefsPath
+	}
+	return filepath.Join(os.Getenv("APPDATA"), "Spotify", "prefs")
+}
+
+// prefsSourcesKey 本地文件来源的键
+func prefsSourcesKey() string {
+	if appConf.SpotifyPrefsSourcesKey != "" {
+		return appConf.SpotifyPrefsSourcesKey
+	}
+	return defaultPrefsSourcesKey
+}
+
+// 切换前的prefs文件备份 存在说明来源已被切换且尚未还原
+func prefsBackupPath() string {
+	return filepath.Join(spotifyConfigBasePath, "spotify_prefs.bak")
+}
+
+// switchLocalSources 分类开始时把spotify的本地文件来源从spotify_local切换到spotify_local_temp
+// prefs只能在客户端关闭时修改(客户端退出时会覆盖) 正在运行时按SpotifyClosePolicy关闭 修改后重新打开
+// 分类期间客户端一直在运行 还原时同样需要关闭它 所以SpotifyClosePolicy为never时不切换
+// 返回false表示没有切换 需要用户手动切换
+func switchLocalSources() bool {
+	if !appConf.SwitchLocalSources {
+		return false
+	}
+	if spotifyClosePolicy() == spotifyCloseNever {
+		fmt.Println("SpotifyClosePolicy为never时无法在分类完成后还原本地文件来源, 不自动切换")
+		return false
+	}
+	prefsPath := spotifyPrefsPath()
+	prefs, err := util.ReadSpotifyPrefs(prefsPath)
+	if err != nil {
+		fmt.Println("找不到spotify的prefs文件: ", err)
+		return false
+	}
+	//关闭客户端之前先确认prefs中有来源的键
+	if _, err := prefs.LocalFileSources(prefsSourcesKey()); err != nil {
+		fmt.Println("无法读取本地文件来源, 请检查SpotifyPrefsSourcesKey: ", err)
+		return false
+	}
+	wasRunning := isSpotifyRunning()
+	if wasRunning && !requestCloseSpotify("切换本地文件来源") {
+		fmt.Println("spotify正在运行, 无法切换本地文件来源")
+		return false
+	}
+	err = editLocalSources(prefsPath)
+	if wasRunning {
+		//分类期间需要使用客户端 立即重新打开 处理完成后是否重新打开取决于还原时是否关闭了它
+		needSpotifyRecover = false
+		openSpotify()
+	}
+	if err != nil {
+		fmt.Println("切换本地文件来源失败: ", err)
+		return false
+	}
+	fmt.Println("已将spotify的本地文件来源切换为spotify_local_temp, 处理完成后会自动还原")
+	return true
+}
+
+// editLocalSources 备份prefs文件后切换来源 上次的备份还在时说明上次没有还原 保留上次的备份
+func editLocalSources(prefsPath string) error {
+	prefs, err := util.ReadSpotifyPrefs(prefsPath)
+	if err != nil {
+		return err
+	}
+	sources, err := prefs.LocalFileSources(prefsSourcesKey())
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(prefsBackupPath()); os.IsNotExist(err) {
+		if err := util.WriteSpotifyPrefs(prefsBackupPath(), prefs); err != nil {
+			return fmt.Errorf("备份prefs文件失败: %w", err)
+		}
+	}
+	prefs.SetLocalFileSources(prefsSourcesKey(), util.SwitchLocalFileSources(sources, spotifyLocalPath, spotifyLocalTempPath))
+	return util.WriteSpotifyPrefs(prefsPath, prefs)
+}
+
+// restoreLocalSources 分类完成后还原本地文件来源 只还原来源的键 分类期间客户端改动的其他设置保留
+// 还原成功后删除备份 失败时保留备份 下次运行时再还原
+func restoreLocalSources() {
+	if _, err := os.Stat(prefsBackupPath()); err != nil {
+		return
+	}
+	if !requestCloseSpotify("还原本地文件来源") {
+		fmt.Print("spotify正在运行, 无法还原本地文件来源, 请手动勾选spotify_local文件夹, 取消勾选spotify_local_temp文件夹\n\n")
+		return
+	}
+	if err := restorePrefsSources(spotifyPrefsPath(), prefsBackupPath()); err != nil {
+		fmt.Println("还原本地文件来源失败: ", err)
+		return
+	}
+	_ = os.Remove(prefsBackupPath())
+	fmt.Println("已还原spotify的本地文件来源")
+}
+
+// restorePrefsSources 用备份中来源的键覆盖当前prefs文件中的值 备份中没有该键时删除
+func restorePrefsSources(prefsPath string, backupPath string) error {
+	backup, err := util.ReadSpotifyPrefs(backupPath)
+	if err != nil {
+		return err
+	}
+	prefs, err := util.ReadSpotifyPrefs(prefsPath)
+	if err != nil {
+		return err
+	}
+	if value, ok := backup.Get(prefsSourcesKey()); ok {
+		prefs.Set(prefsSourcesKey(), value)
+	} else {
+		prefs.Delete(prefsSourcesKey())
+	}
+	return util.WriteSpotifyPrefs(prefsPath, prefs)
+}
+
+// openSpotify 打开spotify客户端
+func openSpotify() {
+	if spotifyAppPath == "" {
+		fmt.Println("Spotify.exe process is not found")
+		return
+	}
+	if err := exec.Command(spotifyAppPath).Start(); err != nil {
+		fmt.Println("打开Spotify失败: ", err)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 暂存策略
+const (
+	//把文件移动到暂存区
+	stagingStrategyMove = "move"
+	//在暂存区创建链接 原文件留在原处 避免云盘重新上传
+	stagingStrategyLink = "link"
+)
+
+// 暂存方式 记录在日志中
+const (
+	stagingMove     = "move"
+	stagingHardlink = "hardlink"
+	stagingSymlink  = "symlink"
+)
+
+// stagingEntry 暂存日志中的一条记录
+type stagingEntry struct {
+	//原文件路径
+	Original string
+	//暂存区中的路径
+	Staged string
+	//暂存方式 move/hardlink/symlink
+	Kind string
+	//暂存时间
+	Time string
+}
+
+// 暂存日志的锁
+var stagingJournalMutex sync.Mutex
+
+// 暂存日志路径 记录每个暂存的文件原来在哪里 用于还原
+func stagingJournalPath() string {
+	return filepath.Join(spotifyConfigBasePath, "staging.json")
+}
+
+// loadStagingJournal 读取暂存日志 调用方需持有stagingJournalMutex
+func loadStagingJournal() []stagingEntry {
+	entries := make([]stagingEntry, 0)
+	journalFile, err := os.Open(stagingJournalPath())
+	if err != nil {
+		return entries
+	}
+	defer journalFile.Close()
+	if err := json.NewDecoder(journalFile).Decode(&entries); err != nil {
+		fmt.Println("暂存日志解析失败: ", err)
+		return make([]stagingEntry, 0)
+	}
+	return entries
+}
+
+// saveStagingJournal 写入暂存日志 调用方需持有stagingJournalMutex
+func saveStagingJournal(entries []stagingEntry) {
+	journalFile, err := os.Create(stagingJournalPath())
+	if err != nil {
+		fmt.Println("无法写入暂存日志: ", err)
+		return
+	}
+	defer journalFile.Close()
+	encoder := json.NewEncoder(journalFile)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(entries)
+}
+
+// recordStaged 记录一个暂存的文件 同一暂存路径的旧记录会被替换
+func recordStaged(entry stagingEntry) {
+	stagingJournalMutex.Lock()
+	defer stagingJournalMutex.Unlock()
+	entries := loadStagingJournal()
+	res := make([]stagingEntry, 0, len(entries)+1)
+	for _, old := range entries {
+		if old.Staged != entry.Staged {
+			res = append(res, old)
+		}
+	}
+	saveStagingJournal(append(res, entry))
+}
+
+// takeStaged 取出并删除暂存路径对应的记录
+func takeStaged(staged string) (stagingEntry, bool) {
+	stagingJournalMutex.Lock()
+	defer stagingJournalMutex.Unlock()
+	entries := loadStagingJournal()
+	res := make([]stagingEntry, 0, len(entries))
+	var found stagingEntry
+	ok := false
+	for _, entry := range entries {
+		if entry.Staged == staged && !ok {
+			found, ok = entry, true
+			continue
+		}
+		res = append(res, entry)
+	}
+	if ok {
+		saveStagingJournal(res)
+	}
+	return found, ok
+}
+
+// stageFile 把曲目放入暂存区 策略为link时依次尝试硬链接和软链接 都失败时改为移动
+// 返回暂存区中的最终路径(冲突策略为suffix时可能带后缀) 已经暂存过时返回空字符串
+func stageFile(original string, staged string) (string, error) {
+	if info, err := os.Stat(staged); err == nil {
+		if originalInfo, err := os.Stat(original); err == nil && os.SameFile(info, originalInfo) {
+			//已经暂存过
+			return "", nil
+		}
+	}
+	if appConf.StagingStrategy == stagingStrategyLink {
+		linkPath, err := stagingLinkPath(original, staged)
+		if err != nil || linkPath == "" {
+			return "", err
+		}
+		kind, err := linkFile(original, linkPath)
+		if err == nil {
+			recordStaged(stagingEntry{Original: original, Staged: linkPath, Kind: kind, Time: time.Now().Format(time.DateTime)})
+			return linkPath, nil
+		}
+		fmt.Println("无法创建链接, 改为移动文件: ", err)
+	}
+	finalPath, err := moveFile(original, staged)
+	if err != nil {
+		return "", err
+	}
+	recordStaged(stagingEntry{Original: original, Staged: finalPath, Kind: stagingMove, Time: time.Now().Format(time.DateTime)})
+	return finalPath, nil
+}
+
+// stagingLinkPath 链接方式暂存时链接的路径 暂存区已有同名的其他文件时按冲突策略处理 不会覆盖
+// 保留两个文件(suffix 或ask时在控制台选择保留)时使用带后缀的链接名 其他情况跳过并返回errMoveConflict
+// 原文件已经用带后缀的链接暂存过时返回空字符串
+func stagingLinkPath(original string, staged string) (string, error) {
+	if _, err := os.Lstat(staged); os.IsNotExist(err) {
+		return staged, nil
+	} else if err != nil {
+		return "", err
+	}
+	originalInfo, err := os.Stat(original)
+	if err != nil {
+		return "", err
+	}
+	for stagedPath, linked := range linkedOriginals() {
+		if linked != filepath.Clean(original) {
+			continue
+		}
+		if info, err := os.Stat(stagedPath); err == nil && os.SameFile(info, originalInfo) {
+			return "", nil
+		}
+	}
+	policy := conflictPolicy()
+	conflict := &moveConflict{
+		Time:       time.Now().Format(time.DateTime),
+		Source:     original,
+		Dest:       staged,
+		Identical:  isIdenticalFile(original, staged),
+		Policy:     policy,
+		Resolution: resolutionSkipped,
+	}
+	keepBoth := policy == conflictSuffix
+	if policy == conflictAsk && !isPageSessionActive() {
+		keepBoth = confirm(fmt.Sprintf("暂存区已有同名文件: %v, 是否用带后缀的名字暂存%v?", staged, original))
+	}
+	//页面上的冲突处理会移动源文件 不适用于链接 跳过后下次运行再暂存
+	if keepBoth {
+		conflict.Resolution = resolutionSuffixed
+		conflict.FinalPath = uniquePath(staged)
+	}
+	recordConflict(conflict)
+	if !keepBoth {
+		return "", errMoveConflict
+	}
+	return conflict.FinalPath, nil
+}
+
+// linkFile 在暂存区创建指向原文件的链接 优先硬链接 跨磁盘等无法硬链接时使用软链接
+// 暂存区已有同名的其他文件时不覆盖
+func linkFile(original string, staged string) (string, error) {
+	if _, err := os.Lstat(staged); err == nil {
+		return "", fmt.Errorf("暂存区已有同名文件: %v", staged)
+	}
+	hardlinkErr := os.Link(original, staged)
+	if hardlinkErr == nil {
+		return stagingHardlink, nil
+	}
+	absOriginal, err := filepath.Abs(original)
+	if err != nil {
+		return "", err
+	}
+	//windows下创建软链接需要开发者模式或管理员权限
+	if err := os.Symlink(absOriginal, staged); err != nil {
+		return "", fmt.Errorf("硬链接: %v, 软链接: %w", hardlinkErr, err)
+	}
+	return stagingSymlink, nil
+}
+
+// unstageFile 曲目分类完成 离开暂存区
+// 链接方式暂存的只需删除链接(原文件不在dest时移动过去) 移动方式暂存的移动到dest
+func unstageFile(staged string, dest string) error {
+	entry, ok := takeStaged(staged)
+	//原文件被删除时 硬链接本身就是完整的文件 和移动方式一样处理
+	if _, err := os.Stat(entry.Original); !ok || entry.Kind == stagingMove || (err != nil && entry.Kind == stagingHardlink) {
+		if _, err := moveFile(staged, dest); err != nil {
+			if ok {
+				//移动失败时保留记录 以便之后还原
+				recordStaged(entry)
+			}
+			return err
+		}
+		return nil
+	}
+	if _, err := os.Stat(entry.Original); err != nil {
+		recordStaged(entry)
+		return fmt.Errorf("原文件不存在: %v", entry.Original)
+	}
+	if err := os.Remove(staged); err != nil {
+		recordStaged(entry)
+		return err
+	}
+	if entry.Original != dest {
+		_, err := moveFile(entry.Original, dest)
+		return err
+	}
+	return nil
+}
+
+// linkedOriginals 链接方式暂存的文件 键为暂存区中的路径 值为原文件路径 路径均已Clean
+// 需要逐个文件查询时先取出整个映射 避免每个文件都读取一次暂存日志
+func linkedOriginals() map[string]string {
+	stagingJournalMutex.Lock()
+	defer stagingJournalMutex.Unlock()
+	res := make(map[string]string)
+	for _, entry := range loadStagingJournal() {
+		if entry.Kind != stagingMove {
+			res[filepath.Clean(entry.Staged)] = filepath.Clean(entry.Original)
+		}
+	}
+	return res
+}
+
+// linkedOriginal 链接方式暂存的文件返回原文件路径 其他文件原样返回
+// 写标签会生成新文件替换原路径 写在链接上会和原文件断开 分类结束删除链接时修改就丢失了
+func linkedOriginal(originals map[string]string, path string) string {
+	if original, ok := originals[filepath.Clean(path)]; ok {
+		return original
+	}
+	return path
+}
+
+// relinkStaged 原文件被替换(如写入标签)后重新创建硬链接 让暂存区的文件和原文件保持一致
+func relinkStaged(original string) {
+	stagingJournalMutex.Lock()
+	defer stagingJournalMutex.Unlock()
+	for _, entry := range loadStagingJournal() {
+		if entry.Kind != stagingHardlink || filepath.Clean(entry.Original) != filepath.Clean(original) {
+			continue
+		}
+		tempPath := entry.Staged + ".relink"
+		if err := os.Link(original, tempPath); err != nil {
+			fmt.Println("无法更新暂存区的链接: ", err)
+			continue
+		}
+		if err := os.Rename(tempPath, entry.Staged); err != nil {
+			_ = os.Remove(tempPath)
+			fmt.Println("无法更新暂存区的链接: ", err)
+		}
+	}
+}
+
+// dropStagedTracks 去掉已经在暂存区的曲目 originals见linkedOriginals
+// 链接方式暂存时原文件仍在歌单文件夹中 每次运行都会再被当作未分类的曲目
+func dropStagedTracks(unHandledTracks []util.MP3MetaInfo, stagedTracks []util.MP3MetaInfo, originals map[string]string) []util.MP3MetaInfo {
+	if len(stagedTracks) == 0 {
+		return unHandledTracks
+	}
+	stagedNames := make(map[string]bool, len(stagedTracks))
+	for _, stagedTrack := range stagedTracks {
+		stagedNames[stagedTrack.FileName] = true
+		//重名时链接带后缀 按原文件名匹配
+		stagedPath := filepath.Join(spotifyLocalTempPath, stagedTrack.PlayListName, stagedTrack.FileName)
+		if original, ok := originals[stagedPath]; ok {
+			stagedNames[filepath.Base(original)] = true
+		}
+	}
+	remaining := make([]util.MP3MetaInfo, 0, len(unHandledTracks))
+	for _, track := range unHandledTracks {
+		if !stagedNames[track.FileName] {
+			remaining = append(remaining, track)
+		}
+	}
+	return remaining
+}
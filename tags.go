@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// tagEditOptions 批量修改标签的选项
+type tagEditOptions struct {
+	//去掉多余的空白
+	normalize bool
+	//全大写/全小写的英文改为首字母大写
+	fixCasing bool
+	//拆分多艺术家 再用artistSeparator连接
+	splitArtists bool
+	//多艺术家的连接符
+	artistSeparator string
+	//从文件名设置标签的模板 为nil时不使用
+	pattern *util.FileNamePattern
+}
+
+// tagChange 一个文件的标签修改
+type tagChange struct {
+	//文件路径
+	Path string
+	//修改前
+	Old util.TagFields
+	//修改后
+	New util.TagFields
+	//有变化的字段
+	Diffs []util.TagDiff
+}
+
+// tagBackup 一个文件修改前的原始标签
+type tagBackup struct {
+	//文件路径
+	Path string
+	//原始的标签字节
+	Raw util.RawTag
+}
+
+// runTags 批量规范化标签 默认只预览修改
+// -normalize -fix-casing -split-artists -pattern: 修改方式 可以组合使用
+// -apply: 备份原始标签后写入
+// restore <备份文件>: 从备份还原标签
+func runTags(args []string) {
+	if len(args) > 0 && args[0] == "restore" {
+		runTagsRestore(args[1:])
+		return
+	}
+	flags := flag.NewFlagSet("tags", flag.ExitOnError)
+	normalize := flags.Bool("normalize", false, "去掉首尾和连续的空白")
+	fixCasing := flags.Bool("fix-casing", false, "全大写或全小写的英文改为每个单词首字母大写")
+	splitArtists := flags.Bool("split-artists", false, "按;、,/feat.等拆分多艺术家 再用-artist-sep连接")
+	artistSeparator := flags.String("artist-sep", ", ", "多艺术家的连接符")
+	pattern := flags.String("pattern", "", "从文件名设置标签的模板 如\"{artist} - {title}\" 占位符: {artist} {title} {album} {track} {year} {_}")
+	apply := flags.Bool("apply", false, "写入修改 不加时只预览")
+	_ = flags.Parse(args)
+
+	options := tagEditOptions{
+		normalize:       *normalize,
+		fixCasing:       *fixCasing,
+		splitArtists:    *splitArtists,
+		artistSeparator: *artistSeparator,
+	}
+	if *pattern != "" {
+		fileNamePattern, err := util.ParseFileNamePattern(*pattern)
+		if err != nil {
+			fmt.Println("文件名模板无效: ", err)
+			os.Exit(1)
+		}
+		options.pattern = fileNamePattern
+	}
+	//默认处理本地文件夹和临时文件夹 也可以在参数后面指定文件夹
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{spotifyLocalPath, spotifyLocalTempPath}
+	}
+
+	changes := planTagChanges(roots, options, linkedOriginals())
+	printTagChanges(changes)
+	if len(changes) == 0 {
+		return
+	}
+	if !*apply {
+		fmt.Println("以上为预览, 加上-apply参数写入标签")
+		return
+	}
+	backupPath, err := backupTags(changes)
+	if err != nil {
+		fmt.Println("备份标签失败, 未做任何修改: ", err)
+		os.Exit(1)
+	}
+	fmt.Println("原始标签已备份到: ", backupPath)
+	failed := 0
+	for _, change := range changes {
+		if err := writeTagDiffs(change.Path, change.Diffs); err != nil {
+			fmt.Printf("写入标签失败: %v: %v\n", change.Path, err)
+			failed++
+		}
+	}
+	fmt.Printf("已修改%d个文件的标签, 失败%d个\n", len(changes)-failed, failed)
+}
+
+// planTagChanges 计算各文件需要的标签修改 没有变化的文件不返回 originals见linkedOriginals
+func planTagChanges(roots []string, options tagEditOptions, originals map[string]string) []tagChange {
+	changes := make([]tagChange, 0)
+	planned := make(map[string]bool)
+	for _, root := range roots {
+		_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || !strings.HasSuffix(strings.ToLower(entry.Name()), ".mp3") {
+				return nil
+			}
+			//链接方式暂存的文件改为修改原文件 原文件也在遍历范围内时只修改一次
+			path = linkedOriginal(originals, path)
+			if planned[path] {
+				return nil
+			}
+			planned[path] = true
+			//没有ID3标签的文件字段都为空 写入时新建标签
+			meta, err := util.ExtractMp3FromPath(path)
+			if err != nil {
+				return nil
+			}
+			oldFields := util.TagFieldsOf(meta)
+			newFields := editTagFields(entry.Name(), oldFields, options)
+			if diffs := util.DiffTagFields(oldFields, newFields); len(diffs) != 0 {
+				changes = append(changes, tagChange{Path: path, Old: oldFields, New: newFields, Diffs: diffs})
+			}
+			return nil
+		})
+	}
+	return changes
+}
+
+// writeTagDiffs 写入有变化的字段 暂存区中指向该文件的硬链接同步更新
+func writeTagDiffs(path string, diffs []util.TagDiff) error {
+	if err := util.WriteTagDiffs(path, diffs); err != nil {
+		return err
+	}
+	relinkStaged(path)
+	return nil
+}
+
+// editTagFields 按选项修改标签字段 先从文件名取值 再做规范化
+func editTagFields(fileName string, fields util.TagFields, options tagEditOptions) util.TagFields {
+	if options.pattern != nil {
+		fields, _ = options.pattern.Apply(fileName, fields)
+	}
+	if options.normalize {
+		fields.Title = util.NormalizeSpace(fields.Title)
+		fields.Artist = util.NormalizeSpace(fields.Artist)
+		fields.Album = util.NormalizeSpace(fields.Album)
+	}
+	if options.fixCasing {
+		fields.Title = util.FixCasing(fields.Title)
+		fields.Artist = util.FixCasing(fields.Artist)
+		fields.Album = util.FixCasing(fields.Album)
+	}
+	if options.splitArtists && fields.Artist != "" {
+		fields.Artist = strings.Join(util.SplitArtists(fields.Artist), options.artistSeparator)
+	}
+	return fields
+}
+
+// printTagChanges 打印标签修改的差异
+func printTagChanges(changes []tagChange) {
+	if len(changes) == 0 {
+		fmt.Println("没有需要修改的标签")
+		return
+	}
+	fmt.Printf("有%d个文件的标签需要修改:\n", len(changes))
+	for _, change := range changes {
+		fmt.Println(change.Path)
+		for _, diff := range change.Diffs {
+			fmt.Printf("  %v: %q => %q\n", diff.Field, diff.Old, diff.New)
+		}
+	}
+}
+
+// backupTags 写入标签之前备份原始的标签字节 返回备份文件路径
+// 备份文件位于 ~/.spotifyLocalManager/tag_backups/时间.json
+func backupTags(changes []tagChange) (string, error) {
+	backups := make([]tagBackup, 0, len(changes))
+	for _, change := range changes {
+		raw, err := util.ReadRawTag(change.Path)
+		if err != nil {
+			return "", fmt.Errorf("%v: %w", change.Path, err)
+		}
+		backups = append(backups, tagBackup{Path: change.Path, Raw: raw})
+	}
+	backupDir := filepath.Join(spotifyConfigBasePath, "tag_backups")
+	if err := os.MkdirAll(backupDir, os.ModeDir); err != nil {
+		return "", err
+	}
+	backupPath := filepath.Join(backupDir, time.Now().Format("20060102-150405.000000")+".json")
+	backupFile, err := os.Create(backupPath)
+	if err != nil {
+		return "", err
+	}
+	defer backupFile.Close()
+	if err := json.NewEncoder(backupFile).Encode(backups); err != nil {
+		return "", err
+	}
+	return backupPath, backupFile.Sync()
+}
+
+// runTagsRestore 从备份文件还原标签
+func runTagsRestore(args []string) {
+	if len(args) != 1 {
+		fmt.Println("用法: tags restore <备份文件>")
+		os.Exit(1)
+	}
+	backupFile, err := os.Open(args[0])
+	if err != nil {
+		fmt.Println("无法读取备份文件: ", err)
+		os.Exit(1)
+	}
+	defer backupFile.Close()
+	backups := make([]tagBackup, 0)
+	if err := json.NewDecoder(backupFile).Decode(&backups); err != nil {
+		fmt.Println("备份文件解析失败: ", err)
+		os.Exit(1)
+	}
+	for _, backup := range backups {
+		if err := util.RestoreRawTag(backup.Path, backup.Raw); err != nil {
+			fmt.Printf("还原失败: %v: %v\n", backup.Path, err)
+			continue
+		}
+		relinkStaged(backup.Path)
+		fmt.Println("已还原: ", backup.Path)
+	}
+}
+
+// retagTracks 用修改后的标签更新曲目列表 edits的键为 歌单名/文件名
+// 被修改的歌单会复制一份新的切片 不影响其他地方持有的旧切片
+func retagTracks(data map[string][]util.MP3MetaInfo, edits map[string]util.TagFields) map[string][]util.MP3MetaInfo {
+	if len(edits) == 0 {
+		return data
+	}
+	res := make(map[string][]util.MP3MetaInfo, len(data))
+	for playListName, tracks := range data {
+		res[playListName] = tracks
+		copied := false
+		for i, track := range tracks {
+			fields, ok := edits[playListName+"/"+track.FileName]
+			if !ok || track.LinkedFrom != "" {
+				continue
+			}
+			if !copied {
+				res[playListName] = append([]util.MP3MetaInfo(nil), tracks...)
+				copied = true
+			}
+			res[playListName][i].Title = fields.Title
+			res[playListName][i].Artist = fields.Artist
+			res[playListName][i].Album = fields.Album
+		}
+	}
+	return res
+}
+
+// serveTrackTags 查询暂存区曲目当前的标签
+func serveTrackTags(c *gin.Context) {
+	trackPath, ok := stagedFilePath(c)
+	if !ok {
+		return
+	}
+	meta, err := util.ExtractMp3FromPath(trackPath)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, util.TagFieldsOf(meta))
+}
+
+// editTrackTags 修改暂存区曲目的标签 ?preview=1时只返回差异不写入
+func editTrackTags(c *gin.Context) {
+	trackPath, ok := stagedFilePath(c)
+	if !ok {
+		return
+	}
+	var newFields util.TagFields
+	if err := c.ShouldBindJSON(&newFields); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	meta, err := util.ExtractMp3FromPath(trackPath)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"message": err.Error()})
+		return
+	}
+	//链接方式暂存的曲目写入原文件
+	change := tagChange{Path: linkedOriginal(linkedOriginals(), trackPath), Old: util.TagFieldsOf(meta), New: newFields}
+	change.Diffs = util.DiffTagFields(change.Old, change.New)
+	if c.Query("preview") != "" || len(change.Diffs) == 0 {
+		c.JSON(http.StatusOK, gin.H{"diffs": change.Diffs})
+		return
+	}
+	backupPath, err := backupTags([]tagChange{change})
+	if err == nil {
+		err = writeTagDiffs(change.Path, change.Diffs)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	addRetaggedTrack(meta.PlayListName, meta.FileName, newFields)
+	c.JSON(http.StatusOK, gin.H{"diffs": change.Diffs, "backup": backupPath})
+}
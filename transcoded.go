@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// transcodedFile 标签被转码的文件
+type transcodedFile struct {
+	//文件路径
+	Path string
+	//识别出的原始编码
+	Encoding string
+	//转码后的标题
+	Title string
+	//转码后的艺术家
+	Artist string
+	//转码后的专辑
+	Album string
+}
+
+// findTranscoded 找出标签被转码的文件 root为曲目所在的根目录
+func findTranscoded(root string, data map[string][]util.MP3MetaInfo) []transcodedFile {
+	res := make([]transcodedFile, 0)
+	for _, playListName := range sortedKeys(data) {
+		for _, track := range data[playListName] {
+			//多歌单归属的副本只统计一次
+			if track.Transcoded == "" || track.LinkedFrom != "" {
+				continue
+			}
+			res = append(res, transcodedFile{
+				Path:     filepath.Join(root, playListName, track.FileName),
+				Encoding: track.Transcoded,
+				Title:    track.Title,
+				Artist:   track.Artist,
+				Album:    track.Album,
+			})
+		}
+	}
+	return res
+}
+
+// reportTranscoded 打印标签被转码的文件 写入transcoded.json
+func reportTranscoded(files []transcodedFile) {
+	if len(files) != 0 {
+		fmt.Printf("有%d个文件的标签从旧编码转码:\n", len(files))
+		for _, file := range files {
+			fmt.Printf("  [%v] %v: %v - %v\n", file.Encoding, file.Path, file.Artist, file.Title)
+		}
+	}
+	transcodedReport, err := os.Create(filepath.Join(spotifyConfigBasePath, "transcoded.json"))
+	if err != nil {
+		fmt.Println("无法创建transcoded.json: ", err)
+		return
+	}
+	defer transcodedReport.Close()
+	encoder := json.NewEncoder(transcodedReport)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(files)
+}
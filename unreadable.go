@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+)
+
+// 失败原因的说明
+var unreadableReasons = map[string]string{
+	util.ReasonZeroByte:       "0字节(可能是云盘占位文件)",
+	util.ReasonTruncatedFrame: "标签被截断",
+	util.ReasonNoAudioFrame:   "找不到音频数据",
+	util.ReasonUnreadable:     "无法读取",
+}
+
+// reportUnreadable 打印无法解析的文件 写入unreadable.json 并提供给分类预览页面
+func reportUnreadable(scanErrors []scanError) {
+	setUnreadable(scanErrors)
+	if len(scanErrors) != 0 {
+		fmt.Printf("有%d个文件无法解析:\n", len(scanErrors))
+		for _, scanErr := range scanErrors {
+			fmt.Printf("  [%v] %v: %v\n", unreadableReasons[scanErr.Reason], scanErr.Path, scanErr.Err)
+		}
+	}
+	unreadableFile, err := os.Create(filepath.Join(spotifyConfigBasePath, "unreadable.json"))
+	if err != nil {
+		fmt.Println("无法创建unreadable.json: ", err)
+		return
+	}
+	defer unreadableFile.Close()
+	encoder := json.NewEncoder(unreadableFile)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(scanErrors)
+}
+
+// quarantineUnreadable 将无法解析的文件移入spotify_quarantine/unreadable 返回的列表中Path为隔离后的路径
+func quarantineUnreadable(scanErrors []scanError) []scanError {
+	res := make([]scanError, 0, len(scanErrors))
+	for _, scanErr := range scanErrors {
+		dest, err := quarantineFile(scanErr.Path, "unreadable")
+		if err != nil {
+			fmt.Println("隔离失败: ", err)
+		} else {
+			fmt.Printf("已隔离: %v => %v\n", scanErr.Path, dest)
+			scanErr.Path = dest
+		}
+		res = append(res, scanErr)
+	}
+	return res
+}
@@ -0,0 +1,185 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// 可识别的旧编码
+const (
+	EncodingGBK  = "gbk"
+	EncodingBig5 = "big5"
+	//标签声明为ISO-8859-1 实际写入的是UTF-8字节
+	EncodingUTF8 = "utf-8"
+	//关闭转码
+	EncodingNone = "none"
+)
+
+var legacyEncodings = map[string]encoding.Encoding{
+	EncodingGBK:  simplifiedchinese.GBK,
+	EncodingBig5: traditionalchinese.Big5,
+}
+
+// legacyEncoding 两种编码都能解释同一段字节时优先使用的编码
+var legacyEncoding = EncodingGBK
+
+// SetLegacyEncoding 设置优先使用的旧编码 gbk/big5/none 为空时使用gbk
+func SetLegacyEncoding(name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "":
+		legacyEncoding = EncodingGBK
+	case EncodingGBK, EncodingBig5, EncodingNone:
+		legacyEncoding = name
+	default:
+		return fmt.Errorf("不支持的编码: %s", name)
+	}
+	return nil
+}
+
+// LegacyEncoding 当前优先使用的旧编码
+func LegacyEncoding() string {
+	return legacyEncoding
+}
+
+// DecodeLegacyText 标签声明为ISO-8859-1时 把解码出的文本还原为原始字节后重新识别编码
+// 返回转码后的文本和识别出的编码 不需要转码时原样返回 编码为空
+func DecodeLegacyText(text string) (string, string) {
+	raw := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r > 0xFF {
+			//不是按ISO-8859-1解码出来的文本
+			return text, ""
+		}
+		raw = append(raw, byte(r))
+	}
+	decoded, name := DecodeLegacyBytes(raw)
+	if name == "" {
+		return text, ""
+	}
+	return decoded, name
+}
+
+// DecodeLegacyBytes 识别原始字节的编码并转为UTF-8 纯ASCII或无法识别时按ISO-8859-1解码 编码为空
+// ISO-8859-1本身也是一个候选(如Björk) 只有中文解读明显更合理时才转码
+func DecodeLegacyBytes(raw []byte) (string, string) {
+	if legacyEncoding == EncodingNone || isASCII(raw) {
+		return latin1String(raw), ""
+	}
+	if utf8.Valid(raw) {
+		return string(raw), EncodingUTF8
+	}
+	bestText, bestName, bestScore := latin1String(raw), "", latin1Score(raw)
+	//优先的编码排在前面 分数相同时胜出
+	for _, name := range []string{legacyEncoding, otherLegacyEncoding()} {
+		decoded, err := legacyEncodings[name].NewDecoder().Bytes(raw)
+		if err != nil || !isCJKReading(string(decoded)) {
+			continue
+		}
+		score := cjkScore(string(decoded))
+		if score > bestScore {
+			bestText, bestName, bestScore = string(decoded), name, score
+		}
+	}
+	return bestText, bestName
+}
+
+// 另一种旧编码
+func otherLegacyEncoding() string {
+	if legacyEncoding == EncodingBig5 {
+		return EncodingGBK
+	}
+	return EncodingBig5
+}
+
+// cjkScore 给解码结果打分 常用汉字和全角标点加分 生僻区段减分 出现替换字符说明解码失败 返回0
+func cjkScore(text string) int {
+	score := 0
+	for _, r := range text {
+		switch {
+		case r == utf8.RuneError:
+			return 0
+		case r < 0x80:
+		case isIdeograph(r):
+			score += 2
+		case r >= 0x3000 && r <= 0x303F, r >= 0xFF00 && r <= 0xFFEF:
+			score++
+		default:
+			score--
+		}
+	}
+	return score
+}
+
+// isCJKReading 转码结果是否像中文 需要至少两个汉字(没有拉丁字母时一个也可以)
+// 紧挨着拉丁字母的单个汉字通常是拉丁字母的重音字符和后一个字节被误读成的汉字(如Björk)
+func isCJKReading(text string) bool {
+	runes := []rune(text)
+	ideographs, letters := 0, 0
+	for i, r := range runes {
+		switch {
+		case isIdeograph(r):
+			ideographs++
+			prev, next := rune(0), rune(0)
+			if i > 0 {
+				prev = runes[i-1]
+			}
+			if i+1 < len(runes) {
+				next = runes[i+1]
+			}
+			if !isIdeograph(prev) && !isIdeograph(next) && (isLatinLetter(prev) || isLatinLetter(next)) {
+				return false
+			}
+		case isLatinLetter(r):
+			letters++
+		}
+	}
+	return ideographs >= 2 || (ideographs == 1 && letters == 0)
+}
+
+// latin1Score 给ISO-8859-1的解读打分 拉丁单词中的重音字母加分 C1控制字符减分
+func latin1Score(raw []byte) int {
+	score := 0
+	for i, b := range raw {
+		switch {
+		case b >= 0x80 && b <= 0x9F:
+			score -= 2
+		case b >= 0xC0 && b != 0xD7 && b != 0xF7:
+			if (i > 0 && isLatinLetter(rune(raw[i-1]))) || (i+1 < len(raw) && isLatinLetter(rune(raw[i+1]))) {
+				score += 2
+			}
+		}
+	}
+	return score
+}
+
+func isIdeograph(r rune) bool {
+	return r >= 0x4E00 && r <= 0x9FFF
+}
+
+func isLatinLetter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+func isASCII(raw []byte) bool {
+	for _, b := range raw {
+		if b >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// latin1String 按ISO-8859-1解码
+func latin1String(raw []byte) string {
+	runes := make([]rune, len(raw))
+	for i, b := range raw {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
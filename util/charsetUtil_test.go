@@ -0,0 +1,41 @@
+package util
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+func TestDecodeLegacyBytes(t *testing.T) {
+	tests := []struct {
+		text     string
+		encoding encoding.Encoding
+		want     string
+	}{
+		//ISO-8859-1的重音字母不能被误读成汉字
+		{text: "Björk", encoding: charmap.ISO8859_1, want: ""},
+		{text: "Sigur Rós", encoding: charmap.ISO8859_1, want: ""},
+		{text: "Mötley Crüe", encoding: charmap.ISO8859_1, want: ""},
+		{text: "Café del Mar", encoding: charmap.ISO8859_1, want: ""},
+		{text: "Jóhann Jóhannsson", encoding: charmap.ISO8859_1, want: ""},
+		{text: "晴天", encoding: simplifiedchinese.GBK, want: EncodingGBK},
+		{text: "爱", encoding: simplifiedchinese.GBK, want: EncodingGBK},
+		{text: "周杰伦 - 七里香 (Live)", encoding: simplifiedchinese.GBK, want: EncodingGBK},
+		{text: "G.E.M.邓紫棋", encoding: simplifiedchinese.GBK, want: EncodingGBK},
+		{text: "周杰倫", encoding: traditionalchinese.Big5, want: EncodingBig5},
+		{text: "陳奕迅 - 十年", encoding: traditionalchinese.Big5, want: EncodingBig5},
+	}
+	for _, test := range tests {
+		raw, err := test.encoding.NewEncoder().Bytes([]byte(test.text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, name := DecodeLegacyBytes(raw)
+		if got != test.text || name != test.want {
+			t.Errorf("DecodeLegacyBytes(%q) = %q, %q, want %q, %q", test.text, got, name, test.text, test.want)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MatchGlob 不区分大小写的通配符匹配 *匹配任意字符(包括/) ?匹配单个字符
+func MatchGlob(pattern, str string) bool {
+	var builder strings.Builder
+	builder.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			builder.WriteString(".*")
+		case '?':
+			builder.WriteString(".")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	builder.WriteString("$")
+	matched, err := regexp.MatchString(builder.String(), strings.TrimSpace(str))
+	return err == nil && matched
+}
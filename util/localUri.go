@@ -0,0 +1,57 @@
+package util
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 本地曲目URI的前缀
+const localURIPrefix = "spotify:local:"
+
+// LocalTrackURI 本地曲目URI中记录的身份信息 spotify:local:艺术家:专辑:标题:秒数
+// 各字段是spotify从文件标签中读出的原始字符串 经过URL编码(空格编码为+)
+type LocalTrackURI struct {
+	Artist string
+	Album  string
+	Title  string
+	//时长(秒)
+	Seconds int
+}
+
+// ParseLocalURI 解析本地曲目的URI
+func ParseLocalURI(uri string) (LocalTrackURI, error) {
+	if !strings.HasPrefix(uri, localURIPrefix) {
+		return LocalTrackURI{}, errors.New("不是本地曲目的URI: " + uri)
+	}
+	//字段中的冒号会被编码为%3A 直接按冒号拆分即可
+	parts := strings.Split(strings.TrimPrefix(uri, localURIPrefix), ":")
+	if len(parts) != 4 {
+		return LocalTrackURI{}, errors.New("本地曲目URI的字段数量不对: " + uri)
+	}
+	fields := make([]string, 3)
+	for i, part := range parts[:3] {
+		field, err := url.QueryUnescape(part)
+		if err != nil {
+			return LocalTrackURI{}, err
+		}
+		fields[i] = field
+	}
+	res := LocalTrackURI{Artist: fields[0], Album: fields[1], Title: fields[2]}
+	if parts[3] != "" {
+		seconds, err := strconv.Atoi(parts[3])
+		if err != nil {
+			return LocalTrackURI{}, errors.New("本地曲目URI的时长无效: " + uri)
+		}
+		res.Seconds = seconds
+	}
+	return res, nil
+}
+
+// Matches 与文件标签精确比较 只忽略首尾空白
+func (u LocalTrackURI) Matches(meta MP3MetaInfo) bool {
+	return strings.TrimSpace(u.Artist) == strings.TrimSpace(meta.Artist) &&
+		strings.TrimSpace(u.Album) == strings.TrimSpace(meta.Album) &&
+		strings.TrimSpace(u.Title) == strings.TrimSpace(meta.Title)
+}
@@ -0,0 +1,65 @@
+package util
+
+import "testing"
+
+func TestParseLocalURI(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want LocalTrackURI
+	}{
+		{
+			uri:  "spotify:local:Daft+Punk:Random+Access+Memories:Get+Lucky+%28feat.+Pharrell+Williams%29:369",
+			want: LocalTrackURI{Artist: "Daft Punk", Album: "Random Access Memories", Title: "Get Lucky (feat. Pharrell Williams)", Seconds: 369},
+		},
+		{
+			uri:  "spotify:local:%E5%91%A8%E6%9D%B0%E4%BC%A6:%E5%8F%B6%E6%83%A0%E7%BE%8E:%E6%99%B4%E5%A4%A9:269",
+			want: LocalTrackURI{Artist: "周杰伦", Album: "叶惠美", Title: "晴天", Seconds: 269},
+		},
+		{
+			//字段中的冒号和加号
+			uri:  "spotify:local:AC%2FDC:Live%3A+1991:1%2B1%3D2:60",
+			want: LocalTrackURI{Artist: "AC/DC", Album: "Live: 1991", Title: "1+1=2", Seconds: 60},
+		},
+		{
+			//没有标签的文件 只有标题(文件名)
+			uri:  "spotify:local:::01.+Yesterday:125",
+			want: LocalTrackURI{Title: "01. Yesterday", Seconds: 125},
+		},
+	}
+	for _, test := range tests {
+		got, err := ParseLocalURI(test.uri)
+		if err != nil {
+			t.Errorf("ParseLocalURI(%q) error: %v", test.uri, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("ParseLocalURI(%q) = %+v, want %+v", test.uri, got, test.want)
+		}
+	}
+}
+
+func TestParseLocalURIInvalid(t *testing.T) {
+	for _, uri := range []string{
+		"spotify:track:4uLU6hMCjMI75M1A2tKUQC",
+		"spotify:local:Artist:Album:Title",
+		"spotify:local:Artist:Album:Title:abc",
+		"spotify:local:Artist:Album:%ZZ:10",
+	} {
+		if _, err := ParseLocalURI(uri); err == nil {
+			t.Errorf("ParseLocalURI(%q) expected error", uri)
+		}
+	}
+}
+
+func TestLocalTrackURIMatches(t *testing.T) {
+	identity, err := ParseLocalURI("spotify:local:Daft+Punk:Random+Access+Memories:Get+Lucky:369")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identity.Matches(MP3MetaInfo{Artist: "Daft Punk", Album: "Random Access Memories", Title: "Get Lucky "}) {
+		t.Error("expected exact match")
+	}
+	if identity.Matches(MP3MetaInfo{Artist: "Daft Punk", Album: "Random Access Memories", Title: "Get Lucky (Radio Edit)"}) {
+		t.Error("expected no match for a different title")
+	}
+}
@@ -0,0 +1,94 @@
+package util
+
+import (
+	"bytes"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// M3UEntry M3U/M3U8播放列表中的一个条目
+type M3UEntry struct {
+	//文件路径或URI 原样保留
+	Location string
+	//#EXTINF中的显示名称 通常为 艺术家 - 标题
+	Title string
+	//#EXTINF中的时长(毫秒) 未知时为0
+	Duration int
+}
+
+// ParseM3U 解析M3U/M3U8播放列表 不是UTF-8的内容(Winamp等旧播放器导出的.m3u)按Windows-1252解码
+func ParseM3U(data []byte) []M3UEntry {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	text := string(data)
+	if !utf8.Valid(data) {
+		if decoded, err := charmap.Windows1252.NewDecoder().Bytes(data); err == nil {
+			text = string(decoded)
+		}
+	}
+	entries := make([]M3UEntry, 0)
+	var pending M3UEntry
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			pending = parseExtInf(strings.TrimPrefix(line, "#EXTINF:"))
+		case strings.HasPrefix(line, "#"):
+			//其他扩展指令和注释
+		default:
+			pending.Location = line
+			entries = append(entries, pending)
+			pending = M3UEntry{}
+		}
+	}
+	return entries
+}
+
+// parseExtInf 解析#EXTINF:时长 属性,显示名称
+func parseExtInf(value string) M3UEntry {
+	entry := M3UEntry{}
+	info, title, _ := strings.Cut(value, ",")
+	entry.Title = strings.TrimSpace(title)
+	//时长后面可能跟着 key="value" 形式的属性
+	if fields := strings.Fields(info); len(fields) != 0 {
+		if seconds, err := strconv.ParseFloat(fields[0], 64); err == nil && seconds > 0 {
+			entry.Duration = int(seconds * 1000)
+		}
+	}
+	return entry
+}
+
+// Fields 从显示名称中拆出艺术家和标题 没有" - "时整个作为标题
+func (entry M3UEntry) Fields() TagFields {
+	if artist, title, ok := strings.Cut(entry.Title, " - "); ok {
+		return TagFields{Artist: strings.TrimSpace(artist), Title: strings.TrimSpace(title)}
+	}
+	return TagFields{Title: entry.Title}
+}
+
+// ResolveM3ULocation 把条目的位置解析为本地文件路径 相对路径相对于播放列表所在的文件夹
+// 网络地址等非本地文件返回空字符串
+func ResolveM3ULocation(location string, baseDir string) string {
+	if strings.Contains(location, "://") {
+		locationURL, err := url.Parse(location)
+		if err != nil || locationURL.Scheme != "file" {
+			return ""
+		}
+		location = locationURL.Path
+		//file:///D:/music/a.mp3 的路径为 /D:/music/a.mp3
+		if len(location) > 2 && location[0] == '/' && location[2] == ':' {
+			location = location[1:]
+		}
+	}
+	//windows播放器导出的路径使用反斜杠
+	location = filepath.FromSlash(strings.ReplaceAll(location, `\`, "/"))
+	if filepath.IsAbs(location) || filepath.VolumeName(location) != "" || strings.HasPrefix(location, string(filepath.Separator)) {
+		return location
+	}
+	return filepath.Join(baseDir, location)
+}
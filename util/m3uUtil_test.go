@@ -0,0 +1,61 @@
+package util
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseM3U(t *testing.T) {
+	data := []byte("\ufeff#EXTM3U\r\n" +
+		"#PLAYLIST:测试\r\n" +
+		"#EXTINF:269,周杰伦 - 晴天\r\n" +
+		"..\\music\\晴天.mp3\r\n" +
+		"\r\n" +
+		"#EXTINF:-1 tvg-id=\"x\",Unknown\r\n" +
+		"file:///D:/music/a%20b.mp3\r\n" +
+		"no_info.mp3\r\n")
+	want := []M3UEntry{
+		{Location: `..\music\晴天.mp3`, Title: "周杰伦 - 晴天", Duration: 269000},
+		{Location: "file:///D:/music/a%20b.mp3", Title: "Unknown"},
+		{Location: "no_info.mp3"},
+	}
+	if got := ParseM3U(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseM3U = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseM3ULegacyEncoding(t *testing.T) {
+	//Windows-1252编码的 #EXTINF:1,Björk – Jóga 不能被当作GBK
+	data := []byte{'#', 'E', 'X', 'T', 'I', 'N', 'F', ':', '1', ',', 'B', 'j', 0xf6, 'r', 'k', ' ', 0x96, ' ', 'J', 0xf3, 'g', 'a', '\n', 'a', '.', 'm', 'p', '3', '\n'}
+	got := ParseM3U(data)
+	if len(got) != 1 || got[0].Title != "Björk – Jóga" {
+		t.Errorf("ParseM3U = %+v, want title Björk – Jóga", got)
+	}
+}
+
+func TestM3UEntryFields(t *testing.T) {
+	if got := (M3UEntry{Title: "周杰伦 - 晴天"}).Fields(); got != (TagFields{Artist: "周杰伦", Title: "晴天"}) {
+		t.Errorf("Fields = %+v", got)
+	}
+	if got := (M3UEntry{Title: "晴天"}).Fields(); got != (TagFields{Title: "晴天"}) {
+		t.Errorf("Fields = %+v", got)
+	}
+}
+
+func TestResolveM3ULocation(t *testing.T) {
+	base := filepath.Join("playlists", "exported")
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{location: `..\music\晴天.mp3`, want: filepath.Join("playlists", "music", "晴天.mp3")},
+		{location: "a.mp3", want: filepath.Join(base, "a.mp3")},
+		{location: "http://example.com/a.mp3", want: ""},
+	}
+	for _, test := range tests {
+		if got := ResolveM3ULocation(test.location, base); got != test.want {
+			t.Errorf("ResolveM3ULocation(%q) = %q, want %q", test.location, got, test.want)
+		}
+	}
+}
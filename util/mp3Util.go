@@ -1,7 +1,11 @@
 package util
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
 
 	"github.com/bogem/id3v2"
@@ -19,23 +23,226 @@ type MP3MetaInfo struct {
 	PlayListName string
 	//文件名称
 	FileName string
+	//多歌单归属: 文件实际所在的歌单文件夹 为空表示文件就在PlayListName文件夹中
+	LinkedFrom string `json:",omitempty"`
+	//标签被转码时识别出的原始编码(gbk/big5/utf-8) 为空表示没有转码
+	Transcoded string `json:",omitempty"`
+	//标签缺失的字段是否从文件名推断而来
+	Inferred bool `json:",omitempty"`
+	//时长(毫秒) 0表示未知
+	Duration int `json:",omitempty"`
+	//spotify歌单中本地曲目的URI 只有从spotify查询到的曲目才有
+	URI string `json:",omitempty"`
 }
 
-// ExtractMp3FromPath 根据路径解析MP3元信息
+// mp3解析失败的原因
+const (
+	//0字节文件 通常是云盘尚未下载到本地的占位文件
+	ReasonZeroByte = "zero-byte"
+	//标签或帧被截断
+	ReasonTruncatedFrame = "truncated-frame"
+	//找不到MPEG音频帧
+	ReasonNoAudioFrame = "no-audio-frame"
+	//其他无法读取的情况(权限,不支持的ID3版本等)
+	ReasonUnreadable = "unreadable"
+)
+
+// Mp3Error mp3解析失败 Reason为上面的原因之一
+type Mp3Error struct {
+	Reason string
+	Err    error
+}
+
+func (e *Mp3Error) Error() string {
+	if e.Err == nil {
+		return e.Reason
+	}
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e *Mp3Error) Unwrap() error {
+	return e.Err
+}
+
+// diagnoseMp3 在解析标签之前检查文件结构
+func diagnoseMp3(mp3Path string) error {
+	file, err := os.Open(mp3Path)
+	if err != nil {
+		return &Mp3Error{Reason: ReasonUnreadable, Err: err}
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return &Mp3Error{Reason: ReasonUnreadable, Err: err}
+	}
+	if stat.Size() == 0 {
+		return &Mp3Error{Reason: ReasonZeroByte}
+	}
+	header := make([]byte, 10)
+	n, _ := file.ReadAt(header, 0)
+	tagSize := id3v2TagSize(header[:n])
+	if tagSize > stat.Size() {
+		return &Mp3Error{Reason: ReasonTruncatedFrame, Err: fmt.Errorf("tag size %d exceeds file size %d", tagSize, stat.Size())}
+	}
+	start, end, err := AudioPayloadRange(file)
+	if err != nil {
+		return &Mp3Error{Reason: ReasonUnreadable, Err: err}
+	}
+	offset, frameHeader, err := findFirstFrame(file, start, end)
+	if err != nil {
+		return &Mp3Error{Reason: ReasonNoAudioFrame, Err: err}
+	}
+	if offset+int64(frameHeader.FrameLength()) > end {
+		return &Mp3Error{Reason: ReasonTruncatedFrame, Err: errors.New("first audio frame is incomplete")}
+	}
+	//没有标签不是错误 音频数据完好的文件照常处理 标签从文件名推断
+	return nil
+}
+
+// ExtractMp3FromPath 根据路径解析MP3元信息 失败时返回*Mp3Error
 func ExtractMp3FromPath(mp3Path string) (MP3MetaInfo, error) {
+	if err := diagnoseMp3(mp3Path); err != nil {
+		return MP3MetaInfo{}, err
+	}
 	mp3Tag, err := id3v2.Open(mp3Path, id3v2.Options{
 		Parse: true,
 	})
 	if err != nil {
-		fmt.Println("err: ", err)
-		return MP3MetaInfo{}, err
+		reason := ReasonUnreadable
+		if errors.Is(err, id3v2.ErrBodyOverflow) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, id3v2.ErrSmallHeaderSize) {
+			reason = ReasonTruncatedFrame
+		}
+		return MP3MetaInfo{}, &Mp3Error{Reason: reason, Err: err}
 	}
+	//不关闭会一直占用文件句柄 导致后续无法移动文件
+	defer mp3Tag.Close()
 	parentDirPath, fileName := filepath.Split(mp3Path)
-	return MP3MetaInfo{
-		Title:        mp3Tag.Title(),
-		Artist:       mp3Tag.Artist(),
-		Album:        mp3Tag.Album(),
+	meta := MP3MetaInfo{
 		PlayListName: filepath.Base(parentDirPath),
 		FileName:     fileName,
-	}, nil
+	}
+	if duration, err := ReadDuration(mp3Path); err == nil {
+		meta.Duration = int(duration.Milliseconds())
+	}
+	meta.Title = readTextFrame(mp3Tag, "Title", &meta.Transcoded)
+	meta.Artist = readTextFrame(mp3Tag, "Artist", &meta.Transcoded)
+	meta.Album = readTextFrame(mp3Tag, "Album/Movie/Show title", &meta.Transcoded)
+	//ID3v2中缺失的字段用ID3v1补全
+	if meta.Title == "" || meta.Artist == "" || meta.Album == "" {
+		if v1, err := ReadID3v1(mp3Path); err == nil && v1 != nil {
+			fillEmpty(&meta.Title, v1.Title, v1.Transcoded, &meta.Transcoded)
+			fillEmpty(&meta.Artist, v1.Artist, v1.Transcoded, &meta.Transcoded)
+			fillEmpty(&meta.Album, v1.Album, v1.Transcoded, &meta.Transcoded)
+		}
+	}
+	return meta, nil
+}
+
+// readTextFrame 读取文本帧 声明为ISO-8859-1的帧按实际内容识别编码 转码时记录编码
+func readTextFrame(mp3Tag *id3v2.Tag, name string, transcoded *string) string {
+	frame := mp3Tag.GetTextFrame(mp3Tag.CommonID(name))
+	if !frame.Encoding.Equals(id3v2.EncodingISO) {
+		return frame.Text
+	}
+	text, encoding := DecodeLegacyText(frame.Text)
+	if encoding != "" {
+		*transcoded = encoding
+	}
+	return text
+}
+
+// fillEmpty 字段为空时使用ID3v1中的值
+func fillEmpty(field *string, value string, encoding string, transcoded *string) {
+	if *field != "" || value == "" {
+		return
+	}
+	*field = value
+	if encoding != "" {
+		*transcoded = encoding
+	}
+}
+
+// ID3v1Tag 文件末尾128字节的ID3v1标签
+type ID3v1Tag struct {
+	Title  string
+	Artist string
+	Album  string
+	Year   string
+	//识别出的原始编码 为空表示没有转码
+	Transcoded string
+}
+
+// ReadID3v1 读取ID3v1标签 文件没有ID3v1标签时返回nil
+// ID3v1没有编码声明 老的中文文件大多直接写入GBK或Big5字节
+func ReadID3v1(mp3Path string) (*ID3v1Tag, error) {
+	file, err := os.Open(mp3Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() < 128 {
+		return nil, nil
+	}
+	buf := make([]byte, 128)
+	if _, err := file.ReadAt(buf, stat.Size()-128); err != nil {
+		return nil, err
+	}
+	if string(buf[:3]) != "TAG" {
+		return nil, nil
+	}
+	tag := &ID3v1Tag{}
+	fields := []struct {
+		value *string
+		raw   []byte
+	}{
+		{&tag.Title, buf[3:33]},
+		{&tag.Artist, buf[33:63]},
+		{&tag.Album, buf[63:93]},
+		{&tag.Year, buf[93:97]},
+	}
+	for _, field := range fields {
+		raw := field.raw
+		if i := bytes.IndexByte(raw, 0); i >= 0 {
+			raw = raw[:i]
+		}
+		text, encoding := DecodeLegacyBytes(bytes.TrimRight(raw, " "))
+		*field.value = text
+		if encoding != "" {
+			tag.Transcoded = encoding
+		}
+	}
+	return tag, nil
+}
+
+// ReadTextFrames 读取mp3所有的文本帧 键为帧ID 自定义文本帧(TXXX)的键为 TXXX:描述
+func ReadTextFrames(mp3Path string) (map[string]string, error) {
+	mp3Tag, err := id3v2.Open(mp3Path, id3v2.Options{
+		Parse: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer mp3Tag.Close()
+	frames := make(map[string]string)
+	for id, framers := range mp3Tag.AllFrames() {
+		for _, framer := range framers {
+			switch frame := framer.(type) {
+			case id3v2.TextFrame:
+				frames[id] = frame.Text
+				if frame.Encoding.Equals(id3v2.EncodingISO) {
+					frames[id], _ = DecodeLegacyText(frame.Text)
+				}
+			case id3v2.UserDefinedTextFrame:
+				frames[id+":"+frame.Description] = frame.Value
+				if frame.Encoding.Equals(id3v2.EncodingISO) {
+					frames[id+":"+frame.Description], _ = DecodeLegacyText(frame.Value)
+				}
+			}
+		}
+	}
+	return frames, nil
 }
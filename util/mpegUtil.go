@@ -0,0 +1,318 @@
+package util
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// MPEG帧头中各版本的比特率表(kbps) 下标为帧头中的比特率索引
+var (
+	bitrateV1L1  = []int{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448}
+	bitrateV1L2  = []int{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384}
+	bitrateV1L3  = []int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	bitrateV2L1  = []int{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256}
+	bitrateV2L23 = []int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+	//采样率表 下标为版本(0:MPEG2.5 2:MPEG2 3:MPEG1)
+	sampleRates = map[byte][]int{
+		0: {11025, 12000, 8000},
+		2: {22050, 24000, 16000},
+		3: {44100, 48000, 32000},
+	}
+)
+
+// ErrNoMpegFrame 文件中找不到有效的MPEG音频帧
+var ErrNoMpegFrame = errors.New("no mpeg audio frame")
+
+// MpegFrameHeader MPEG音频帧头
+type MpegFrameHeader struct {
+	//版本 0:MPEG2.5 2:MPEG2 3:MPEG1
+	Version byte
+	//层 1/2/3
+	Layer int
+	//比特率 kbps
+	Bitrate int
+	//采样率 Hz
+	SampleRate int
+	//是否有填充字节
+	Padding bool
+	//声道模式 3为单声道
+	ChannelMode byte
+}
+
+// ParseMpegFrameHeader 解析4字节的帧头
+func ParseMpegFrameHeader(b []byte) (MpegFrameHeader, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return MpegFrameHeader{}, false
+	}
+	version := (b[1] >> 3) & 0x03
+	layerBits := (b[1] >> 1) & 0x03
+	bitrateIndex := b[2] >> 4
+	sampleRateIndex := (b[2] >> 2) & 0x03
+	if version == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 0x0F || sampleRateIndex == 3 {
+		return MpegFrameHeader{}, false
+	}
+	header := MpegFrameHeader{
+		Version:     version,
+		Layer:       4 - int(layerBits),
+		SampleRate:  sampleRates[version][sampleRateIndex],
+		Padding:     (b[2]>>1)&0x01 == 1,
+		ChannelMode: b[3] >> 6,
+	}
+	switch {
+	case version == 3 && header.Layer == 1:
+		header.Bitrate = bitrateV1L1[bitrateIndex]
+	case version == 3 && header.Layer == 2:
+		header.Bitrate = bitrateV1L2[bitrateIndex]
+	case version == 3:
+		header.Bitrate = bitrateV1L3[bitrateIndex]
+	case header.Layer == 1:
+		header.Bitrate = bitrateV2L1[bitrateIndex]
+	default:
+		header.Bitrate = bitrateV2L23[bitrateIndex]
+	}
+	return header, true
+}
+
+// SamplesPerFrame 每帧的采样数
+func (h MpegFrameHeader) SamplesPerFrame() int {
+	switch {
+	case h.Layer == 1:
+		return 384
+	case h.Layer == 2 || h.Version == 3:
+		return 1152
+	default:
+		return 576
+	}
+}
+
+// FrameLength 帧长度(字节 包含帧头)
+func (h MpegFrameHeader) FrameLength() int {
+	padding := 0
+	if h.Padding {
+		padding = 1
+	}
+	if h.Layer == 1 {
+		return (12*h.Bitrate*1000/h.SampleRate + padding) * 4
+	}
+	return h.SamplesPerFrame()/8*h.Bitrate*1000/h.SampleRate + padding
+}
+
+// id3v2TagSize 根据ID3v2标签头计算整个标签的长度 不是ID3v2标签头时返回0
+func id3v2TagSize(header []byte) int64 {
+	if len(header) < 10 || string(header[:3]) != "ID3" {
+		return 0
+	}
+	//标签大小为synchsafe整数 每个字节只用低7位
+	size := int64(header[6]&0x7F)<<21 | int64(header[7]&0x7F)<<14 | int64(header[8]&0x7F)<<7 | int64(header[9]&0x7F)
+	size += 10
+	if header[5]&0x10 != 0 {
+		//存在footer
+		size += 10
+	}
+	return size
+}
+
+// AudioPayloadRange 计算音频数据在文件中的范围 跳过开头的ID3v2标签和末尾的ID3v1标签
+func AudioPayloadRange(file *os.File) (start int64, end int64, err error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	end = stat.Size()
+	header := make([]byte, 10)
+	if _, err := file.ReadAt(header, 0); err == nil {
+		start = id3v2TagSize(header)
+	}
+	if end >= 128 {
+		tail := make([]byte, 3)
+		if _, err := file.ReadAt(tail, end-128); err == nil && string(tail) == "TAG" {
+			end -= 128
+		}
+	}
+	if start > end {
+		start = end
+	}
+	return start, end, nil
+}
+
+// HashFile 计算整个文件的sha1
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha1.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// HashAudioPayload 只计算音频数据的sha1 修改标签不影响结果
+func HashAudioPayload(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	start, end, err := AudioPayloadRange(file)
+	if err != nil {
+		return "", err
+	}
+	hash := sha1.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, start, end-start)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findFirstFrame 在音频数据中找到第一个有效帧 要求紧随其后的也是有效帧 避免误判
+func findFirstFrame(file *os.File, start int64, end int64) (int64, MpegFrameHeader, error) {
+	//最多向后搜索64KB
+	limit := end - start
+	if limit > 64*1024 {
+		limit = 64 * 1024
+	}
+	buf := make([]byte, limit)
+	n, err := file.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, MpegFrameHeader{}, err
+	}
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		header, ok := ParseMpegFrameHeader(buf[i:])
+		if !ok {
+			continue
+		}
+		next := i + header.FrameLength()
+		if next+4 <= len(buf) {
+			if _, ok := ParseMpegFrameHeader(buf[next:]); !ok {
+				continue
+			}
+		}
+		return start + int64(i), header, nil
+	}
+	return 0, MpegFrameHeader{}, ErrNoMpegFrame
+}
+
+// ReadBitrate 读取第一个音频帧的比特率(kbps)
+func ReadBitrate(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	start, end, err := AudioPayloadRange(file)
+	if err != nil {
+		return 0, err
+	}
+	_, header, err := findFirstFrame(file, start, end)
+	if err != nil {
+		return 0, err
+	}
+	return header.Bitrate, nil
+}
+
+// QuickHash 文件的快速指纹: 大小 + 开头和末尾各64KB的sha1
+// 开头包含ID3v2标签 修改标签后指纹会变化 用于识别重命名和移动过的文件
+func QuickHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	const sampleSize = 64 * 1024
+	hash := sha1.New()
+	_, _ = fmt.Fprintf(hash, "%d:", stat.Size())
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, sampleSize)); err != nil {
+		return "", err
+	}
+	if stat.Size() > sampleSize {
+		tailStart := stat.Size() - sampleSize
+		if tailStart < sampleSize {
+			tailStart = sampleSize
+		}
+		if _, err := io.Copy(hash, io.NewSectionReader(file, tailStart, stat.Size()-tailStart)); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sideInfoSize 帧头之后side information的长度 Xing头紧随其后
+func (h MpegFrameHeader) sideInfoSize() int {
+	mono := h.ChannelMode == 3
+	switch {
+	case h.Version == 3 && mono:
+		return 17
+	case h.Version == 3:
+		return 32
+	case mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// vbrFrameCount 读取第一帧中Xing/Info或VBRI头记录的总帧数 没有时返回0
+func vbrFrameCount(frame []byte, header MpegFrameHeader) int64 {
+	//Xing/Info头位于side information之后
+	xingOffset := 4 + header.sideInfoSize()
+	if len(frame) >= xingOffset+12 {
+		tag := string(frame[xingOffset : xingOffset+4])
+		flags := binary.BigEndian.Uint32(frame[xingOffset+4:])
+		if (tag == "Xing" || tag == "Info") && flags&0x01 != 0 {
+			return int64(binary.BigEndian.Uint32(frame[xingOffset+8:]))
+		}
+	}
+	//VBRI头固定位于帧头之后32字节处
+	const vbriOffset = 4 + 32
+	if len(frame) >= vbriOffset+18 && string(frame[vbriOffset:vbriOffset+4]) == "VBRI" {
+		return int64(binary.BigEndian.Uint32(frame[vbriOffset+14:]))
+	}
+	return 0
+}
+
+// audioDuration 计算音频时长 优先使用VBR头中的总帧数 没有时按第一帧的比特率当作CBR估算
+func audioDuration(file *os.File, start int64, end int64) (time.Duration, error) {
+	offset, header, err := findFirstFrame(file, start, end)
+	if err != nil {
+		return 0, err
+	}
+	frame := make([]byte, header.FrameLength())
+	n, err := file.ReadAt(frame, offset)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	samples := int64(header.SamplesPerFrame())
+	if frames := vbrFrameCount(frame[:n], header); frames > 0 {
+		return time.Duration(frames * samples * int64(time.Second) / int64(header.SampleRate)), nil
+	}
+	bits := (end - offset) * 8
+	return time.Duration(bits * int64(time.Second) / int64(header.Bitrate*1000)), nil
+}
+
+// ReadDuration 读取mp3的时长
+func ReadDuration(path string) (time.Duration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	start, end, err := AudioPayloadRange(file)
+	if err != nil {
+		return 0, err
+	}
+	return audioDuration(file, start, end)
+}
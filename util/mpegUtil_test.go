@@ -0,0 +1,293 @@
+package util
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试用的帧头
+var (
+	//MPEG1 Layer3 128kbps 44.1kHz 立体声
+	headerV1L3Stereo = []byte{0xFF, 0xFB, 0x90, 0x00}
+	//MPEG1 Layer3 128kbps 44.1kHz 单声道
+	headerV1L3Mono = []byte{0xFF, 0xFB, 0x90, 0xC0}
+	//MPEG2 Layer3 64kbps 22.05kHz 单声道
+	headerV2L3Mono = []byte{0xFF, 0xF3, 0x80, 0xC0}
+	//MPEG2 Layer3 64kbps 22.05kHz 立体声
+	headerV2L3Stereo = []byte{0xFF, 0xF3, 0x80, 0x00}
+	//MPEG2.5 Layer3 32kbps 11.025kHz 立体声
+	headerV25L3Stereo = []byte{0xFF, 0xE3, 0x40, 0x00}
+)
+
+// mpegFrame 生成指定长度的帧 帧头之后填0
+func mpegFrame(header []byte, length int) []byte {
+	frame := make([]byte, length)
+	copy(frame, header)
+	return frame
+}
+
+// withXing 在帧中写入带总帧数的Xing/Info头
+func withXing(frame []byte, offset int, tag string, frames uint32) []byte {
+	copy(frame[offset:], tag)
+	binary.BigEndian.PutUint32(frame[offset+4:], 0x01)
+	binary.BigEndian.PutUint32(frame[offset+8:], frames)
+	return frame
+}
+
+// withVBRI 在帧中写入VBRI头
+func withVBRI(frame []byte, frames uint32) []byte {
+	copy(frame[36:], "VBRI")
+	binary.BigEndian.PutUint32(frame[36+14:], frames)
+	return frame
+}
+
+func TestParseMpegFrameHeader(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      []byte
+		want        MpegFrameHeader
+		frameLength int
+		samples     int
+	}{
+		{
+			name:        "MPEG1 Layer3 立体声",
+			header:      headerV1L3Stereo,
+			want:        MpegFrameHeader{Version: 3, Layer: 3, Bitrate: 128, SampleRate: 44100},
+			frameLength: 417,
+			samples:     1152,
+		},
+		{
+			name:        "MPEG1 Layer3 填充",
+			header:      []byte{0xFF, 0xFB, 0x92, 0x00},
+			want:        MpegFrameHeader{Version: 3, Layer: 3, Bitrate: 128, SampleRate: 44100, Padding: true},
+			frameLength: 418,
+			samples:     1152,
+		},
+		{
+			name:        "MPEG1 Layer3 单声道",
+			header:      headerV1L3Mono,
+			want:        MpegFrameHeader{Version: 3, Layer: 3, Bitrate: 128, SampleRate: 44100, ChannelMode: 3},
+			frameLength: 417,
+			samples:     1152,
+		},
+		{
+			name:        "MPEG1 Layer2",
+			header:      []byte{0xFF, 0xFD, 0xA4, 0x00},
+			want:        MpegFrameHeader{Version: 3, Layer: 2, Bitrate: 192, SampleRate: 48000},
+			frameLength: 576,
+			samples:     1152,
+		},
+		{
+			name:        "MPEG1 Layer1",
+			header:      []byte{0xFF, 0xFF, 0xC0, 0x00},
+			want:        MpegFrameHeader{Version: 3, Layer: 1, Bitrate: 384, SampleRate: 44100},
+			frameLength: 416,
+			samples:     384,
+		},
+		{
+			name:        "MPEG2 Layer3 单声道",
+			header:      headerV2L3Mono,
+			want:        MpegFrameHeader{Version: 2, Layer: 3, Bitrate: 64, SampleRate: 22050, ChannelMode: 3},
+			frameLength: 208,
+			samples:     576,
+		},
+		{
+			name:        "MPEG2 Layer3 立体声",
+			header:      headerV2L3Stereo,
+			want:        MpegFrameHeader{Version: 2, Layer: 3, Bitrate: 64, SampleRate: 22050},
+			frameLength: 208,
+			samples:     576,
+		},
+		{
+			name:        "MPEG2.5 Layer3 立体声",
+			header:      headerV25L3Stereo,
+			want:        MpegFrameHeader{Version: 0, Layer: 3, Bitrate: 32, SampleRate: 11025},
+			frameLength: 208,
+			samples:     576,
+		},
+	}
+	for _, test := range tests {
+		got, ok := ParseMpegFrameHeader(test.header)
+		if !ok || got != test.want {
+			t.Errorf("%v: ParseMpegFrameHeader = %+v, %v, want %+v", test.name, got, ok, test.want)
+			continue
+		}
+		if length := got.FrameLength(); length != test.frameLength {
+			t.Errorf("%v: FrameLength = %d, want %d", test.name, length, test.frameLength)
+		}
+		if samples := got.SamplesPerFrame(); samples != test.samples {
+			t.Errorf("%v: SamplesPerFrame = %d, want %d", test.name, samples, test.samples)
+		}
+	}
+}
+
+func TestParseMpegFrameHeaderInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"太短":     {0xFF, 0xFB, 0x90},
+		"没有同步字":  {0xFE, 0xFB, 0x90, 0x00},
+		"保留的版本":  {0xFF, 0xEB, 0x90, 0x00},
+		"保留的层":   {0xFF, 0xF9, 0x90, 0x00},
+		"空闲比特率":  {0xFF, 0xFB, 0x00, 0x00},
+		"无效的比特率": {0xFF, 0xFB, 0xF0, 0x00},
+		"保留的采样率": {0xFF, 0xFB, 0x9C, 0x00},
+	}
+	for name, header := range tests {
+		if got, ok := ParseMpegFrameHeader(header); ok {
+			t.Errorf("%v: ParseMpegFrameHeader(% X) = %+v, want invalid", name, header, got)
+		}
+	}
+}
+
+func TestVbrFrameCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		frame  func(frame []byte) []byte
+		want   int64
+	}{
+		{
+			name:   "MPEG1 立体声 Xing",
+			header: headerV1L3Stereo,
+			frame:  func(frame []byte) []byte { return withXing(frame, 4+32, "Xing", 1000) },
+			want:   1000,
+		},
+		{
+			name:   "MPEG1 单声道 Xing",
+			header: headerV1L3Mono,
+			frame:  func(frame []byte) []byte { return withXing(frame, 4+17, "Xing", 1000) },
+			want:   1000,
+		},
+		{
+			name:   "MPEG2 单声道 Info",
+			header: headerV2L3Mono,
+			frame:  func(frame []byte) []byte { return withXing(frame, 4+9, "Info", 500) },
+			want:   500,
+		},
+		{
+			name:   "MPEG2.5 立体声 Xing",
+			header: headerV25L3Stereo,
+			frame:  func(frame []byte) []byte { return withXing(frame, 4+17, "Xing", 300) },
+			want:   300,
+		},
+		{
+			name:   "Xing位置不对",
+			header: headerV1L3Mono,
+			frame:  func(frame []byte) []byte { return withXing(frame, 4+32, "Xing", 1000) },
+			want:   0,
+		},
+		{
+			name:   "Xing没有帧数",
+			header: headerV1L3Stereo,
+			frame: func(frame []byte) []byte {
+				withXing(frame, 4+32, "Xing", 1000)
+				binary.BigEndian.PutUint32(frame[4+32+4:], 0)
+				return frame
+			},
+			want: 0,
+		},
+		{
+			name:   "VBRI",
+			header: headerV1L3Stereo,
+			frame:  func(frame []byte) []byte { return withVBRI(frame, 2000) },
+			want:   2000,
+		},
+		{
+			name:   "CBR",
+			header: headerV1L3Stereo,
+			frame:  func(frame []byte) []byte { return frame },
+			want:   0,
+		},
+	}
+	for _, test := range tests {
+		header, _ := ParseMpegFrameHeader(test.header)
+		frame := test.frame(mpegFrame(test.header, header.FrameLength()))
+		if got := vbrFrameCount(frame, header); got != test.want {
+			t.Errorf("%v: vbrFrameCount = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAudioDuration(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		//文件开头的ID3v2标签
+		prefix []byte
+		header []byte
+		first  func(frame []byte) []byte
+		frames int
+		want   time.Duration
+	}{
+		{
+			name:   "MPEG1 CBR",
+			header: headerV1L3Stereo,
+			frames: 10,
+			//10帧 * 417字节 * 8 / 128kbps
+			want: 10 * 417 * 8 * time.Second / 128000,
+		},
+		{
+			name:   "MPEG2.5 CBR",
+			header: headerV25L3Stereo,
+			frames: 10,
+			want:   10 * 208 * 8 * time.Second / 32000,
+		},
+		{
+			name:   "跳过ID3v2标签",
+			prefix: []byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 20},
+			header: headerV1L3Stereo,
+			frames: 10,
+			want:   10 * 417 * 8 * time.Second / 128000,
+		},
+		{
+			name:   "MPEG1 Xing",
+			header: headerV1L3Stereo,
+			first:  func(frame []byte) []byte { return withXing(frame, 4+32, "Xing", 1000) },
+			frames: 3,
+			want:   1000 * 1152 * time.Second / 44100,
+		},
+		{
+			name:   "MPEG2 单声道 Xing",
+			header: headerV2L3Mono,
+			first:  func(frame []byte) []byte { return withXing(frame, 4+9, "Xing", 1000) },
+			frames: 3,
+			want:   1000 * 576 * time.Second / 22050,
+		},
+		{
+			name:   "MPEG2 立体声 Xing",
+			header: headerV2L3Stereo,
+			first:  func(frame []byte) []byte { return withXing(frame, 4+17, "Xing", 1000) },
+			frames: 3,
+			want:   1000 * 576 * time.Second / 22050,
+		},
+		{
+			name:   "MPEG1 VBRI",
+			header: headerV1L3Stereo,
+			first:  func(frame []byte) []byte { return withVBRI(frame, 2000) },
+			frames: 3,
+			want:   2000 * 1152 * time.Second / 44100,
+		},
+	}
+	for i, test := range tests {
+		header, _ := ParseMpegFrameHeader(test.header)
+		data := append([]byte(nil), test.prefix...)
+		data = append(data, make([]byte, id3v2TagSize(test.prefix)-int64(len(test.prefix)))...)
+		for j := 0; j < test.frames; j++ {
+			frame := mpegFrame(test.header, header.FrameLength())
+			if j == 0 && test.first != nil {
+				frame = test.first(frame)
+			}
+			data = append(data, frame...)
+		}
+		path := filepath.Join(dir, string(rune('a'+i))+".mp3")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadDuration(path)
+		if err != nil || got != test.want {
+			t.Errorf("%v: ReadDuration = %v, %v, want %v", test.name, got, err, test.want)
+		}
+	}
+}
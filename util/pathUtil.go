@@ -0,0 +1,34 @@
+package util
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// IsSubPath 判断target是否位于base目录之内(不含base本身)
+func IsSubPath(base, target string) bool {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return false
+	}
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	return !filepath.IsAbs(rel)
+}
+
+// SanitizeFileName 把windows文件名中不允许的字符替换为_ 用于以歌单名等作为文件名
+func SanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	//windows不允许以点或空格结尾
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+	return name
+}
@@ -0,0 +1,139 @@
+package util
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// 文件名模板支持的占位符及其匹配规则
+var patternFields = map[string]string{
+	"artist": `(.+?)`,
+	"title":  `(.+?)`,
+	"album":  `(.+?)`,
+	"track":  `(\d+)`,
+	"year":   `(\d{4})`,
+	//匹配任意内容但不使用
+	"_": `(.*?)`,
+}
+
+var placeholderRegex = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// FileNamePattern 文件名模板 如 "{artist} - {title}" 匹配时不包含扩展名
+type FileNamePattern struct {
+	//原始模板
+	Pattern string
+	regex   *regexp.Regexp
+	//各分组对应的占位符
+	fields []string
+}
+
+// ParseFileNamePattern 解析文件名模板 占位符为{artist} {title} {album} {track} {year} {_}
+func ParseFileNamePattern(pattern string) (*FileNamePattern, error) {
+	res := &FileNamePattern{Pattern: pattern, fields: make([]string, 0)}
+	expr := strings.Builder{}
+	expr.WriteString(`^\s*`)
+	last := 0
+	for _, loc := range placeholderRegex.FindAllStringSubmatchIndex(pattern, -1) {
+		name := strings.ToLower(pattern[loc[2]:loc[3]])
+		group, ok := patternFields[name]
+		if !ok {
+			return nil, errors.New("未知的占位符: {" + name + "}")
+		}
+		expr.WriteString(literalPattern(pattern[last:loc[0]]))
+		expr.WriteString(group)
+		res.fields = append(res.fields, name)
+		last = loc[1]
+	}
+	expr.WriteString(literalPattern(pattern[last:]))
+	expr.WriteString(`\s*$`)
+	if len(res.fields) == 0 {
+		return nil, errors.New("模板中没有占位符: " + pattern)
+	}
+	regex, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+	res.regex = regex
+	return res, nil
+}
+
+// literalPattern 模板中的普通文本 其中的空白可以匹配任意数量的空白
+func literalPattern(literal string) string {
+	parts := spaceRegex.Split(literal, -1)
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return strings.Join(parts, `\s*`)
+}
+
+// Match 用模板匹配文件名(去掉扩展名) 返回各占位符的值 任何一个值为空都认为不匹配
+func (p *FileNamePattern) Match(fileName string) (map[string]string, bool) {
+	return p.MatchName(strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName)))
+}
+
+// MatchName 用模板匹配不带扩展名的名称
+func (p *FileNamePattern) MatchName(name string) (map[string]string, bool) {
+	groups := p.regex.FindStringSubmatch(name)
+	if groups == nil {
+		return nil, false
+	}
+	res := make(map[string]string)
+	for i, field := range p.fields {
+		value := NormalizeSpace(groups[i+1])
+		if field == "_" {
+			continue
+		}
+		if value == "" {
+			return nil, false
+		}
+		res[field] = value
+	}
+	return res, true
+}
+
+// Apply 用文件名中解析出的值覆盖标签字段
+func (p *FileNamePattern) Apply(fileName string, fields TagFields) (TagFields, bool) {
+	values, ok := p.Match(fileName)
+	if !ok {
+		return fields, false
+	}
+	if value, ok := values["title"]; ok {
+		fields.Title = value
+	}
+	if value, ok := values["artist"]; ok {
+		fields.Artist = value
+	}
+	if value, ok := values["album"]; ok {
+		fields.Album = value
+	}
+	return fields, true
+}
+
+// InferTagFields 用第一个匹配的模板补全为空的字段 name为不带扩展名的文件名
+// 没有模板匹配时和spotify一样把文件名作为标题 第二个返回值表示是否有模板匹配
+func InferTagFields(name string, fields TagFields, patterns []*FileNamePattern) (TagFields, bool) {
+	matched := false
+	for _, pattern := range patterns {
+		values, ok := pattern.MatchName(name)
+		if !ok {
+			continue
+		}
+		if fields.Title == "" {
+			fields.Title = values["title"]
+		}
+		if fields.Artist == "" {
+			fields.Artist = values["artist"]
+		}
+		if fields.Album == "" {
+			fields.Album = values["album"]
+		}
+		matched = true
+		break
+	}
+	if fields.Title == "" {
+		fields.Title = NormalizeSpace(name)
+	}
+	return fields, matched
+}
@@ -0,0 +1,182 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SpotifyPrefs spotify客户端的prefs文件 每行一个 键=值 字符串值带引号
+// 只修改用到的键 其他行(包括注释和无法解析的行)原样保留
+type SpotifyPrefs struct {
+	lines []prefsLine
+	//原文件的换行符
+	newline string
+}
+
+// prefsLine prefs文件中的一行
+type prefsLine struct {
+	//键 不是 键=值 格式的行为空
+	key string
+	//原始的整行内容 没有修改过时原样写回
+	text string
+}
+
+// ParseSpotifyPrefs 解析prefs文件内容
+func ParseSpotifyPrefs(data []byte) *SpotifyPrefs {
+	prefs := &SpotifyPrefs{newline: "\n"}
+	if bytes.Contains(data, []byte("\r\n")) {
+		prefs.newline = "\r\n"
+	}
+	text := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if text == "" {
+		return prefs
+	}
+	for _, line := range strings.Split(text, "\n") {
+		key := ""
+		if index := strings.Index(line, "="); index > 0 && !strings.HasPrefix(line, "#") {
+			key = strings.TrimSpace(line[:index])
+		}
+		prefs.lines = append(prefs.lines, prefsLine{key: key, text: line})
+	}
+	return prefs
+}
+
+// ReadSpotifyPrefs 读取prefs文件
+func ReadSpotifyPrefs(path string) (*SpotifyPrefs, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSpotifyPrefs(data), nil
+}
+
+// Get 读取键的值 带引号的值会去掉引号并还原转义
+func (prefs *SpotifyPrefs) Get(key string) (string, bool) {
+	for _, line := range prefs.lines {
+		if line.key != key {
+			continue
+		}
+		raw := strings.TrimSpace(line.text[strings.Index(line.text, "=")+1:])
+		if strings.HasPrefix(raw, `"`) {
+			if value, err := strconv.Unquote(raw); err == nil {
+				return value, true
+			}
+		}
+		return raw, true
+	}
+	return "", false
+}
+
+// Set 设置键的字符串值 键不存在时追加到末尾
+func (prefs *SpotifyPrefs) Set(key string, value string) {
+	text := key + "=" + strconv.Quote(value)
+	for i, line := range prefs.lines {
+		if line.key == key {
+			prefs.lines[i].text = text
+			return
+		}
+	}
+	prefs.lines = append(prefs.lines, prefsLine{key: key, text: text})
+}
+
+// Delete 删除键
+func (prefs *SpotifyPrefs) Delete(key string) {
+	res := prefs.lines[:0]
+	for _, line := range prefs.lines {
+		if line.key != key {
+			res = append(res, line)
+		}
+	}
+	prefs.lines = res
+}
+
+// Bytes 序列化为prefs文件内容 使用原文件的换行符
+func (prefs *SpotifyPrefs) Bytes() []byte {
+	var buffer bytes.Buffer
+	for _, line := range prefs.lines {
+		buffer.WriteString(line.text)
+		buffer.WriteString(prefs.newline)
+	}
+	return buffer.Bytes()
+}
+
+// WriteSpotifyPrefs 写入prefs文件 先写临时文件再改名 写入中途出错不会损坏原文件
+func WriteSpotifyPrefs(path string, prefs *SpotifyPrefs) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(path), ".prefs.*.tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	_, err = tempFile.Write(prefs.Bytes())
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+	}
+	return err
+}
+
+// LocalFileSources 读取本地文件来源文件夹列表 值为JSON数组
+// 键不存在时返回错误 不同版本客户端的键可能不同 凭空添加的键客户端不会读取
+func (prefs *SpotifyPrefs) LocalFileSources(key string) ([]string, error) {
+	value, ok := prefs.Get(key)
+	if !ok {
+		return nil, fmt.Errorf("prefs中没有%v", key)
+	}
+	if strings.TrimSpace(value) == "" {
+		return make([]string, 0), nil
+	}
+	sources := make([]string, 0)
+	if err := json.Unmarshal([]byte(value), &sources); err != nil {
+		return nil, fmt.Errorf("无法解析%v: %w", key, err)
+	}
+	return sources, nil
+}
+
+// SetLocalFileSources 写入本地文件来源文件夹列表
+func (prefs *SpotifyPrefs) SetLocalFileSources(key string, sources []string) {
+	if sources == nil {
+		sources = make([]string, 0)
+	}
+	value, _ := json.Marshal(sources)
+	prefs.Set(key, string(value))
+}
+
+// SwitchLocalFileSources 从来源列表中去掉remove 加上add 路径不区分大小写比较 返回新的列表
+func SwitchLocalFileSources(sources []string, remove string, add string) []string {
+	res := make([]string, 0, len(sources)+1)
+	hasAdd := false
+	for _, source := range sources {
+		if isSamePath(source, remove) {
+			continue
+		}
+		if isSamePath(source, add) {
+			hasAdd = true
+		}
+		res = append(res, source)
+	}
+	if !hasAdd {
+		res = append(res, add)
+	}
+	return res
+}
+
+// isSamePath 两个路径是否相同 忽略大小写和末尾的分隔符
+func isSamePath(path1 string, path2 string) bool {
+	clean := func(path string) string {
+		return strings.TrimRight(strings.ReplaceAll(path, `\`, "/"), "/")
+	}
+	return strings.EqualFold(clean(path1), clean(path2))
+}
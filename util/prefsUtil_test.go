@@ -0,0 +1,146 @@
+package util
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 来源的键由配置文件决定 测试使用单独的键 不依赖默认值
+const testSourcesKey = "test.local-files.sources"
+
+func readTestPrefs(t *testing.T, name string) (*SpotifyPrefs, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "prefs", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ParseSpotifyPrefs(data), data
+}
+
+func TestSpotifyPrefsRoundTrip(t *testing.T) {
+	for _, name := range []string{"sources.prefs", "crlf.prefs", "no_sources.prefs"} {
+		prefs, data := readTestPrefs(t, name)
+		if got := prefs.Bytes(); !bytes.Equal(got, data) {
+			t.Errorf("%v: 未修改时内容不一致:\n%q\nwant\n%q", name, got, data)
+		}
+	}
+}
+
+func TestSpotifyPrefsGet(t *testing.T) {
+	prefs, _ := readTestPrefs(t, "sources.prefs")
+	tests := []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{key: "app.player.volume", want: "45875", ok: true},
+		{key: "app.last-launched-version", want: "1.2.31.1205.g4d59ad7c", ok: true},
+		{key: "storage.last-location", want: `C:\Users\me\AppData\Local\Spotify\Storage`, ok: true},
+		{key: "app.missing", want: "", ok: false},
+	}
+	for _, test := range tests {
+		got, ok := prefs.Get(test.key)
+		if got != test.want || ok != test.ok {
+			t.Errorf("Get(%q) = %q, %v, want %q, %v", test.key, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestLocalFileSources(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "sources.prefs", want: []string{`D:\spotify\spotify_local`, `C:\Users\me\Music`}},
+		{name: "crlf.prefs", want: []string{`D:\音乐\spotify_local`}},
+	}
+	for _, test := range tests {
+		prefs, _ := readTestPrefs(t, test.name)
+		got, err := prefs.LocalFileSources(testSourcesKey)
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: LocalFileSources = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLocalFileSourcesMissingKey(t *testing.T) {
+	prefs, _ := readTestPrefs(t, "no_sources.prefs")
+	if _, err := prefs.LocalFileSources(testSourcesKey); err == nil {
+		t.Error("no_sources.prefs: 键不存在时应返回错误")
+	}
+	prefs, _ = readTestPrefs(t, "sources.prefs")
+	if _, err := prefs.LocalFileSources("app.local-files.sources"); err == nil {
+		t.Error("sources.prefs: 键不存在时应返回错误")
+	}
+	prefs = ParseSpotifyPrefs([]byte(testSourcesKey + "=\n"))
+	if got, err := prefs.LocalFileSources(testSourcesKey); err != nil || len(got) != 0 {
+		t.Errorf("空值 = %q, %v, want 空列表", got, err)
+	}
+}
+
+func TestSwitchLocalFileSources(t *testing.T) {
+	prefs, data := readTestPrefs(t, "sources.prefs")
+	sources, _ := prefs.LocalFileSources(testSourcesKey)
+	switched := SwitchLocalFileSources(sources, `d:\spotify\spotify_local\`, `D:\spotify\spotify_local_temp`)
+	want := []string{`C:\Users\me\Music`, `D:\spotify\spotify_local_temp`}
+	if !reflect.DeepEqual(switched, want) {
+		t.Fatalf("SwitchLocalFileSources = %q, want %q", switched, want)
+	}
+	prefs.SetLocalFileSources(testSourcesKey, switched)
+
+	//写入后重新解析 其他行不变
+	reparsed := ParseSpotifyPrefs(prefs.Bytes())
+	got, err := reparsed.LocalFileSources(testSourcesKey)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("重新解析后 = %q, %v, want %q", got, err, want)
+	}
+	original := ParseSpotifyPrefs(data)
+	for _, key := range []string{"core.clock_delta", "app.player.volume", "storage.last-location"} {
+		value, _ := reparsed.Get(key)
+		originalValue, _ := original.Get(key)
+		if value != originalValue {
+			t.Errorf("%v 被修改: %q, want %q", key, value, originalValue)
+		}
+	}
+
+	//已经切换过时不重复添加
+	if again := SwitchLocalFileSources(switched, `D:\spotify\spotify_local`, `D:\spotify\spotify_local_temp`); !reflect.DeepEqual(again, want) {
+		t.Errorf("重复切换 = %q, want %q", again, want)
+	}
+}
+
+func TestSpotifyPrefsSetMissingKey(t *testing.T) {
+	prefs, _ := readTestPrefs(t, "crlf.prefs")
+	prefs.Delete(testSourcesKey)
+	if _, ok := prefs.Get(testSourcesKey); ok {
+		t.Fatal("Delete后仍能读取到键")
+	}
+	prefs.SetLocalFileSources(testSourcesKey, []string{`D:\音乐\spotify_local_temp`})
+	want := "core.clock_delta=0\r\napp.player.volume=45875\r\n" + `test.local-files.sources="[\"D:\\\\音乐\\\\spotify_local_temp\"]"` + "\r\n"
+	if got := string(prefs.Bytes()); got != want {
+		t.Errorf("Bytes = %q, want %q", got, want)
+	}
+}
+
+func TestWriteSpotifyPrefs(t *testing.T) {
+	prefs, data := readTestPrefs(t, "sources.prefs")
+	path := filepath.Join(t.TempDir(), "prefs")
+	if err := WriteSpotifyPrefs(path, prefs); err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(written, data) {
+		t.Errorf("写入的内容不一致: %v", err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("临时文件没有清理: %v", entries)
+	}
+}
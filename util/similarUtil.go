@@ -47,6 +47,10 @@ func min(a, b int) int {
 func EvaluateSimilar(str1, str2 string) bool {
 	str1 = strings.ToLower(strings.TrimSpace(str1))
 	str2 = strings.ToLower(strings.TrimSpace(str2))
+	if str1 == str2 {
+		//两边都为空时下面会除以0
+		return true
+	}
 	editDistance := calculateEditDistance(str1, str2)
 	similarity := 1 - float64(editDistance)/float64(max(len(str1), len(str2)))
 	return similarity > 0.8
@@ -0,0 +1,210 @@
+package util
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/bogem/id3v2"
+)
+
+// TagFields 可以编辑的标签字段
+type TagFields struct {
+	Title  string
+	Artist string
+	Album  string
+}
+
+// TagFieldsOf 取出元信息中的标签字段
+func TagFieldsOf(meta MP3MetaInfo) TagFields {
+	return TagFields{Title: meta.Title, Artist: meta.Artist, Album: meta.Album}
+}
+
+// TagDiff 单个字段的变化
+type TagDiff struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// DiffTagFields 比较两组标签字段 只返回有变化的字段
+func DiffTagFields(oldFields TagFields, newFields TagFields) []TagDiff {
+	diffs := make([]TagDiff, 0)
+	for _, field := range []TagDiff{
+		{"Title", oldFields.Title, newFields.Title},
+		{"Artist", oldFields.Artist, newFields.Artist},
+		{"Album", oldFields.Album, newFields.Album},
+	} {
+		if field.Old != field.New {
+			diffs = append(diffs, field)
+		}
+	}
+	return diffs
+}
+
+// 标签字段对应的ID3帧名称
+var tagFrameNames = map[string]string{
+	"Title":  "Title",
+	"Artist": "Artist",
+	"Album":  "Album/Movie/Show title",
+}
+
+// WriteTagDiffs 只写入有变化的字段 其他字段和帧保持不变 没有ID3标签的文件会新建标签
+// ID3v2.4使用UTF-8 ID3v2.3不支持UTF-8 使用UTF-16
+func WriteTagDiffs(mp3Path string, diffs []TagDiff) error {
+	mp3Tag, err := id3v2.Open(mp3Path, id3v2.Options{
+		Parse: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer mp3Tag.Close()
+	encoding := id3v2.EncodingUTF8
+	if mp3Tag.Version() < 4 {
+		encoding = id3v2.EncodingUTF16
+	}
+	for _, diff := range diffs {
+		id := mp3Tag.CommonID(tagFrameNames[diff.Field])
+		mp3Tag.DeleteFrames(id)
+		if diff.New != "" {
+			mp3Tag.AddTextFrame(id, encoding, diff.New)
+		}
+	}
+	return mp3Tag.Save()
+}
+
+// RawTag 文件中原始的标签字节 用于备份和还原
+type RawTag struct {
+	//文件开头的ID3v2标签 没有时为空
+	ID3v2 []byte
+	//文件末尾128字节的ID3v1标签 没有时为空
+	ID3v1 []byte
+}
+
+// ReadRawTag 读取文件中原始的标签字节
+func ReadRawTag(mp3Path string) (RawTag, error) {
+	file, err := os.Open(mp3Path)
+	if err != nil {
+		return RawTag{}, err
+	}
+	defer file.Close()
+	start, end, err := AudioPayloadRange(file)
+	if err != nil {
+		return RawTag{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return RawTag{}, err
+	}
+	raw := RawTag{}
+	if start > 0 {
+		raw.ID3v2 = make([]byte, start)
+		if _, err := file.ReadAt(raw.ID3v2, 0); err != nil {
+			return RawTag{}, err
+		}
+	}
+	if end < stat.Size() {
+		raw.ID3v1 = make([]byte, stat.Size()-end)
+		if _, err := file.ReadAt(raw.ID3v1, end); err != nil {
+			return RawTag{}, err
+		}
+	}
+	return raw, nil
+}
+
+// RestoreRawTag 用备份的标签字节替换文件当前的标签 音频数据保持不变
+func RestoreRawTag(mp3Path string, raw RawTag) error {
+	file, err := os.Open(mp3Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	start, end, err := AudioPayloadRange(file)
+	if err != nil {
+		return err
+	}
+	//先写入同目录的临时文件 成功后再替换 避免写到一半损坏原文件
+	tempFile, err := os.CreateTemp(filepath.Dir(mp3Path), ".restore-*.tmp")
+	if err != nil {
+		return err
+	}
+	tempPath := tempFile.Name()
+	writeErr := func() error {
+		if _, err := tempFile.Write(raw.ID3v2); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tempFile, io.NewSectionReader(file, start, end-start)); err != nil {
+			return err
+		}
+		if _, err := tempFile.Write(raw.ID3v1); err != nil {
+			return err
+		}
+		return tempFile.Chmod(stat.Mode())
+	}()
+	closeErr := tempFile.Close()
+	if writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		_ = os.Remove(tempPath)
+		return writeErr
+	}
+	//windows下替换前需要先关闭原文件
+	_ = file.Close()
+	if err := os.Rename(tempPath, mp3Path); err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+var spaceRegex = regexp.MustCompile(`\s+`)
+
+// NormalizeSpace 去掉首尾空白 连续的空白合并为一个空格
+func NormalizeSpace(text string) string {
+	return spaceRegex.ReplaceAllString(strings.TrimSpace(text), " ")
+}
+
+// FixCasing 全大写或全小写的拉丁字母文本改为每个单词首字母大写 大小写混合的文本认为是有意为之 保持不变
+func FixCasing(text string) string {
+	hasUpper, hasLower := false, false
+	for _, r := range text {
+		if r > unicode.MaxLatin1 && unicode.IsLetter(r) && !unicode.In(r, unicode.Latin) {
+			//包含汉字等没有大小写的文字时不处理
+			return text
+		}
+		hasUpper = hasUpper || unicode.IsUpper(r)
+		hasLower = hasLower || unicode.IsLower(r)
+	}
+	if hasUpper == hasLower {
+		return text
+	}
+	runes := []rune(strings.ToLower(text))
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) || strings.ContainsRune("([-/\"", runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
+
+// 多艺术家的分隔符 不包含&和不带空格的/ 避免拆开Simon & Garfunkel AC/DC这样的名字
+var artistSeparatorRegex = regexp.MustCompile(`\s*(?:;|；|、|,|，| / |\s+(?i:feat\.?|ft\.|featuring)\s+)\s*`)
+
+// SplitArtists 按常见的分隔符拆分多个艺术家
+func SplitArtists(artist string) []string {
+	res := make([]string, 0)
+	for _, name := range artistSeparatorRegex.Split(artist, -1) {
+		if name = NormalizeSpace(name); name != "" {
+			res = append(res, name)
+		}
+	}
+	return res
+}
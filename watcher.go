@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nichuanfang/spotify-local-manager/util"
+	"github.com/zmb3/spotify/v2"
+)
+
+const (
+	//轮询间隔
+	pollInterval = 2 * time.Second
+	//防抖时间 文件在这段时间内没有新的变化才会被处理 避免处理下载到一半的文件
+	debounceInterval = 3 * time.Second
+)
+
+// fsWatcher 文件变化的来源 Events()输出新增或修改的文件路径
+type fsWatcher interface {
+	Events() <-chan string
+	Close() error
+}
+
+// pollWatcher 轮询实现 适用于所有平台
+type pollWatcher struct {
+	root   string
+	events chan string
+	done   chan struct{}
+}
+
+// 文件快照
+type fileSnapshot struct {
+	size    int64
+	modTime time.Time
+}
+
+// newPollWatcher 创建轮询监听器 启动时已存在的文件不会被视为新文件
+func newPollWatcher(root string) *pollWatcher {
+	w := &pollWatcher{
+		root:   root,
+		events: make(chan string),
+		done:   make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+func (w *pollWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
+
+// 扫描目录 返回所有文件的快照
+func (w *pollWatcher) scan() map[string]fileSnapshot {
+	snapshots := make(map[string]fileSnapshot)
+	_ = filepath.Walk(w.root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		snapshots[path] = fileSnapshot{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return snapshots
+}
+
+func (w *pollWatcher) loop() {
+	previous := w.scan()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			current := w.scan()
+			for path, snapshot := range current {
+				if old, ok := previous[path]; ok && old == snapshot {
+					continue
+				}
+				select {
+				case w.events <- path:
+				case <-w.done:
+					return
+				}
+			}
+			previous = current
+		}
+	}
+}
+
+// newFsWatcher 优先使用系统原生的文件通知 不支持时退化为轮询
+func newFsWatcher(root string) fsWatcher {
+	watcher, err := newNativeWatcher(root)
+	if err != nil {
+		fmt.Println("无法使用系统文件通知, 改为轮询: ", err)
+		return newPollWatcher(root)
+	}
+	return watcher
+}
+
+// debounce 对文件变化防抖 在安静期之后批量输出
+func debounce(ctx context.Context, events <-chan string, onBatch func(paths []string)) {
+	pending := make(map[string]struct{})
+	timer := time.NewTimer(debounceInterval)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case path, ok := <-events:
+			if !ok {
+				return
+			}
+			pending[path] = struct{}{}
+			timer.Reset(debounceInterval)
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			pending = make(map[string]struct{})
+			onBatch(paths)
+		}
+	}
+}
+
+// watchLocalLibrary 监听spotify_local中新下载的曲目 并将未收录到歌单的新曲目暂存待分类
+func watchLocalLibrary(ctx context.Context) {
+	watcher := newFsWatcher(spotifyLocalPath)
+	defer watcher.Close()
+	sp := newSessionClient(ctx)
+	fmt.Println("正在监听spotify_local中的新曲目...")
+	debounce(ctx, watcher.Events(), func(paths []string) {
+		stageNewDownloads(ctx, sp, paths)
+	})
+}
+
+// stageNewDownloads 解析新文件的元信息 与在线歌单比对后只暂存尚未分类的新文件
+func stageNewDownloads(ctx context.Context, sp *spotify.Client, paths []string) {
+	newTracks := make(map[string][]util.MP3MetaInfo)
+	for _, path := range paths {
+		if !strings.HasSuffix(strings.ToLower(path), ".mp3") {
+			continue
+		}
+		//只处理spotify_local/<歌单>/<文件> 这一层
+		rel, err := filepath.Rel(spotifyLocalPath, path)
+		if err != nil || len(strings.Split(rel, string(filepath.Separator))) != 2 {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			//文件已被移走
+			continue
+		}
+		mp3, err := util.ExtractMp3FromPath(path)
+		if err != nil {
+			continue
+		}
+		if _, ok := playListMap[mp3.PlayListName]; !ok {
+			continue
+		}
+		newTracks[mp3.PlayListName] = append(newTracks[mp3.PlayListName], mp3)
+	}
+	for playListName, localTracks := range newTracks {
+		tracks, err := getTracksByPlayList(sp, ctx, spotify.SimplePlaylist{ID: playListMap[playListName], Name: playListName})
+		if err != nil {
+			continue
+		}
+		unHandledTracks, _ := diffTracks(localTracks, tracks)
+		if len(unHandledTracks) == 0 {
+			continue
+		}
+		stagedTracks := moveToTemp(unHandledTracks, playListName)
+		if len(stagedTracks) == 0 {
+			continue
+		}
+		addStagedTracks(playListName, stagedTracks)
+		fmt.Printf("歌单: %v 新增%d首待分类曲目\n", playListName, len(stagedTracks))
+	}
+}
@@ -0,0 +1,126 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// 关注的inotify事件: 写入完成 移入 新建
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// inotifyWatcher 基于inotify的监听器 会递归监听所有子目录
+// fd为非阻塞模式并交给os.File 由运行时的网络轮询器等待 Close时阻塞中的Read会立即返回
+type inotifyWatcher struct {
+	fd        int
+	file      *os.File
+	mutex     sync.Mutex
+	watches   map[int]string
+	events    chan string
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newNativeWatcher 创建inotify监听器
+func newNativeWatcher(root string) (fsWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		watches: make(map[int]string),
+		events:  make(chan string),
+		done:    make(chan struct{}),
+	}
+	if err := w.addTree(root, false); err != nil {
+		_ = w.file.Close()
+		return nil, err
+	}
+	go w.loop()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan string {
+	return w.events
+}
+
+func (w *inotifyWatcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.file.Close()
+	})
+	return err
+}
+
+// send 输出事件 已关闭时返回false
+func (w *inotifyWatcher) send(path string) bool {
+	select {
+	case w.events <- path:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// addTree 监听目录及其子目录 emitFiles为true时把目录中已存在的文件也作为事件输出(整个文件夹被移入的情况)
+func (w *inotifyWatcher) addTree(root string, emitFiles bool) error {
+	return filepath.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !info.IsDir() {
+			if emitFiles && !w.send(path) {
+				return fs.SkipAll
+			}
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.mutex.Lock()
+		w.watches[wd] = path
+		w.mutex.Unlock()
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) loop() {
+	defer close(w.events)
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil || n <= 0 {
+			return
+		}
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+			w.mutex.Lock()
+			dir, ok := w.watches[int(event.Wd)]
+			w.mutex.Unlock()
+			if !ok {
+				continue
+			}
+			path := filepath.Join(dir, string(bytes.TrimRight(nameBytes, "\x00")))
+			if event.Mask&syscall.IN_ISDIR != 0 {
+				_ = w.addTree(path, true)
+				continue
+			}
+			//IN_CREATE之后文件可能还在写入 以IN_CLOSE_WRITE为准 防抖会合并这两个事件
+			if !w.send(path) {
+				return
+			}
+		}
+	}
+}
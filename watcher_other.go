@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// newNativeWatcher 非linux平台暂无原生实现 使用轮询
+func newNativeWatcher(root string) (fsWatcher, error) {
+	return nil, errors.New("unsupported platform")
+}